@@ -5,6 +5,7 @@ import (
 	"math"
 	"math/big"
 	"math/rand"
+	"os"
 	"time"
 )
 
@@ -12,8 +13,31 @@ import (
 
 /*** Operators ***/
 
+// ExpBigInt computes a^b by repeated squaring, checking for interruption between
+// steps so that huge powers can be cancelled.
 func ExpBigInt(a, b *big.Int) (r *big.Int, err error) {
-	r = a.Exp(a, b, nil)
+	if b.Sign() <= 0 {
+		r = a.Exp(a, b, nil)
+		return
+	}
+
+	base := cloneInt(a)
+	if a == b {
+		b = base
+	}
+	r = a.SetInt64(1)
+	for i := b.BitLen() - 1; i >= 0; i-- {
+		if err = checkInterrupt(); err != nil {
+			return nil, err
+		}
+		r.Mul(r, r)
+		if b.Bit(i) == 1 {
+			r.Mul(r, base)
+		}
+		if err = checkIntBits(big.NewInt(int64(r.BitLen()))); err != nil {
+			return nil, err
+		}
+	}
 	return
 }
 
@@ -113,6 +137,12 @@ func lsh(a, b interface{}) (r *big.Int, err error) {
 	if !aIsInt || !bIsInt {
 		return nil, fmt.Errorf("the '<<' operation is only defined for integer expressions")
 	}
+	if bi.Sign() < 0 {
+		return nil, fmt.Errorf("shift count must not be negative")
+	}
+	if !bi.IsUint64() || bi.Uint64() > uint64(^uint(0)>>1) {
+		return nil, fmt.Errorf("shift count %v is too large", bi)
+	}
 	r = ai.Lsh(ai, uint(bi.Uint64()))
 	return
 }
@@ -123,6 +153,17 @@ func rsh(a, b interface{}) (r *big.Int, err error) {
 	if !aIsInt || !bIsInt {
 		return nil, fmt.Errorf("the '>>' operation is only defined for integer expressions")
 	}
+	if bi.Sign() < 0 {
+		return nil, fmt.Errorf("shift count must not be negative")
+	}
+	// Shifting by the bit length or more leaves 0, or -1 for negative numbers. Checking
+	// the count first keeps a huge one from being truncated.
+	if !bi.IsUint64() || bi.Uint64() >= uint64(ai.BitLen()) {
+		if ai.Sign() < 0 {
+			return big.NewInt(-1), nil
+		}
+		return big.NewInt(0), nil
+	}
 	r = ai.Rsh(ai, uint(bi.Uint64()))
 	return
 }
@@ -130,52 +171,101 @@ func rsh(a, b interface{}) (r *big.Int, err error) {
 /*** General ***/
 
 func binom(n, k *big.Int) (*big.Int, error) {
+	// For k <= n/2, binom(n, k) >= 2^k. Check that the result will fit before computing it.
+	if n.Sign() >= 0 && k.Sign() >= 0 && k.Cmp(n) <= 0 {
+		kk := big.NewInt(0).Sub(n, k)
+		if k.Cmp(kk) < 0 {
+			kk = k
+		}
+		if err := checkIntBits(kk); err != nil {
+			return nil, err
+		}
+	}
 	b := big.NewInt(0)
 	return b.Binomial(n.Int64(), k.Int64()), nil
 }
 
 func bit(n, i *big.Int) (*big.Int, error) {
+	if i.Sign() < 0 {
+		return nil, fmt.Errorf("bit index must not be negative")
+	}
+	// The bits above the bit length are 0, or 1 for negative numbers in two's
+	// complement. Checking the index first keeps a huge one from being truncated.
+	if i.Cmp(big.NewInt(int64(n.BitLen()))) >= 0 {
+		if n.Sign() < 0 {
+			return big.NewInt(1), nil
+		}
+		return big.NewInt(0), nil
+	}
 	return big.NewInt(int64(n.Bit(int(i.Int64())))), nil
 }
 
+// env returns the value of the environment variable name. Values that are numbers
+// are returned as numbers, others as strings.
+func env(name string) (interface{}, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	if n, err := parseNumber(v); err == nil {
+		return n, nil
+	}
+	return v, nil
+}
+
 func now() (*big.Int, error) {
 	t := time.Now()
 	return big.NewInt(int64(time.Duration(t.UnixNano()) / time.Millisecond)), nil
 }
 
 func roll(num, sides *big.Int) (*big.Int, error) {
+	if sides.Sign() <= 0 {
+		return nil, fmt.Errorf("dice must have at least one side")
+	}
 	sum := int64(0)
 	sd := sides.Int64()
 	for i := int64(0); i < num.Int64(); i++ {
+		if i%(1<<16) == 0 {
+			if err := checkInterrupt(); err != nil {
+				return nil, err
+			}
+		}
 		sum += rand.Int63n(sd) + 1
 	}
 	return big.NewInt(sum), nil
 }
 
+// float64Result converts the float64 result of a math library function to a value. NaN
+// and infinite results are errors unless the ieee setting is on.
+func float64Result(f float64) (interface{}, error) {
+	if math.IsNaN(f) {
+		if ieee {
+			return NaN{}, nil
+		}
+		return nil, NewErrNaN("the argument is outside the function's domain")
+	}
+	return checkFloat(big.NewFloat(f))
+}
+
 func wrapFloat64FuncWith1Arg(inFn interface{}) (outFn interface{}) {
-	return func(a *big.Float) (*big.Float, error) {
+	return func(a *big.Float) (interface{}, error) {
 		af, _ := a.Float64()
 		f := inFn.(func(f float64) float64)
-		return big.NewFloat(f(af)), nil
+		return float64Result(f(af))
 	}
 }
 
 func wrapFloat64FuncWith2Arg(inFn interface{}) (outFn interface{}) {
-	return func(a, b *big.Float) (*big.Float, error) {
+	return func(a, b *big.Float) (interface{}, error) {
 		af, _ := a.Float64()
 		bf, _ := b.Float64()
 		f := inFn.(func(f, h float64) float64)
-		return big.NewFloat(f(af, bf)), nil
+		return float64Result(f(af, bf))
 	}
 }
 
 func getBytes(i *big.Int) (l BigIntList, err error) {
-	b := i.Bytes()
-	l = make(BigIntList, len(b))
-	for i, v := range b {
-		l[i] = big.NewInt(int64(v))
-	}
-	return
+	return bytesToList(i.Bytes()), nil
 }
 
 /*** List functions ***/
@@ -186,6 +276,8 @@ func listLen(l interface{}) (*big.Int, error) {
 		return big.NewInt(int64(len(t))), nil
 	case BigFloatList:
 		return big.NewInt(int64(len(t))), nil
+	case IPAddrList:
+		return big.NewInt(int64(len(t))), nil
 	}
 	return nil, fmt.Errorf("Unsupported type for llen")
 }
@@ -208,6 +300,8 @@ func listIndex(l interface{}, i *big.Int) (interface{}, error) {
 		return cloneInt(t[ndx]), nil
 	case BigFloatList:
 		return cloneFloat(t[ndx]), nil
+	case IPAddrList:
+		return t[ndx].clone(), nil
 	}
 
 	return nil, fmt.Errorf("Unsupported type for parameter 1")
@@ -215,17 +309,26 @@ func listIndex(l interface{}, i *big.Int) (interface{}, error) {
 
 func listReverse(l interface{}) (l2 interface{}, err error) {
 
-	switch l.(type) {
+	switch t := l.(type) {
 	case BigIntList:
 		return listReverseBigInt(l)
 	case BigFloatList:
 		return listReverseBigFloat(l)
+	case IPAddrList:
+		return listReverseIPAddrList(t), nil
 	}
 
 	return nil, fmt.Errorf("Unsupported type for parameter 1")
 }
 
 func listRepeat(e interface{}, n *big.Int) (l interface{}, err error) {
+	if n.Sign() < 0 {
+		return nil, fmt.Errorf("Repeat count must be positive")
+	}
+	if err = checkListLen(n); err != nil {
+		return
+	}
+
 	switch t := e.(type) {
 	case *big.Int:
 		return listRepeatBigInt(t, n)
@@ -237,13 +340,9 @@ func listRepeat(e interface{}, n *big.Int) (l interface{}, err error) {
 }
 
 func unbytes(l BigIntList) (*big.Int, error) {
-	bytes := make([]byte, len(l))
-	for i, v := range l {
-		n := v.Uint64()
-		if n > 255 {
-			return nil, fmt.Errorf("Value too large for byte")
-		}
-		bytes[i] = byte(n)
+	bytes, err := listToBytes(l)
+	if err != nil {
+		return nil, err
 	}
 	return big.NewInt(0).SetBytes(bytes), nil
 }
@@ -254,6 +353,8 @@ func listMap(l interface{}, fn Func) (interface{}, error) {
 		return listMapBigIntList(t, fn)
 	case BigFloatList:
 		return listMapBigFloatList(t, fn)
+	case IPAddrList:
+		return listMapIPAddrList(t, fn)
 	}
 
 	return nil, fmt.Errorf("Unsupported type for parameter 1")
@@ -273,6 +374,12 @@ func listReduce(l interface{}, fn Func, memo interface{}) (interface{}, error) {
 			return nil, fmt.Errorf("Type of initial value does not match type contained in list (list contains floats)")
 		}
 		return listReduceBigFloatList(t, fn, m)
+	case IPAddrList:
+		m, ok := memo.(IPAddr)
+		if !ok {
+			return nil, fmt.Errorf("Type of initial value does not match type contained in list (list contains ip addresses)")
+		}
+		return listReduceIPAddrList(t, fn, m)
 	}
 
 	return nil, fmt.Errorf("Unsupported type for parameter 1")
@@ -284,6 +391,8 @@ func listFilter(l interface{}, fn Func) (interface{}, error) {
 		return listFilterBigIntList(t, fn)
 	case BigFloatList:
 		return listFilterBigFloatList(t, fn)
+	case IPAddrList:
+		return listFilterIPAddrList(t, fn)
 	}
 
 	return nil, fmt.Errorf("Unsupported type for parameter 1")
@@ -331,7 +440,7 @@ func registerStdlibMath() {
 
 	reg("abs", math.Abs, "absolute value")
 	reg("acos", math.Acos, "arccosine")
-	reg("acosh", math.Acos, "inverse hyperbolic cosine")
+	reg("acosh", math.Acosh, "inverse hyperbolic cosine")
 	reg("asin", math.Asin, "arcsine")
 	reg("asinh", math.Asinh, "inverse hyperbolic sine")
 	reg("atan", math.Atan, "arctangent")
@@ -341,10 +450,10 @@ func registerStdlibMath() {
 	reg("cos", math.Cos, "cosine")
 	reg("cosh", math.Cosh, "hyperbolic cosine")
 	reg("erf", math.Erf, "error function")
-	reg("erfc", math.Erfc, "error function compliment")
+	reg("erfc", math.Erfc, "complementary error function")
 	reg("exp", math.Exp, "calculates e^p1, the base-e exponential of p1")
 	reg("exp2", math.Exp2, "calculates 2^p1, the base-2 exponential of p1")
-	reg("exp10", math.Pow10, "calculates 10^p1, the base-10 exponential of p1")
+	reg("exp10", func(x float64) float64 { return math.Pow(10, x) }, "calculates 10^p1, the base-10 exponential of p1")
 	reg("floor", math.Floor, "floor")
 	reg("gamma", math.Gamma, "gamma function")
 	reg("j0", math.J0, "order zero bessel function of the first kind")
@@ -367,23 +476,27 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 
 	/*** Operators ***/
-	RegisterBuiltin("+", add, "return p1 + p2")
-	RegisterBuiltin("-", sub, "return p1 - p2")
-	RegisterBuiltin("*", mul, "return p1 * p2")
-	RegisterBuiltin("/", quo, "return p1 / p2")
-	RegisterBuiltin("^", exp, "return p1 ^ p2")
+	RegisterBuiltin("+", binaryOpFunc("+"), "return p1 + p2")
+	RegisterBuiltin("-", binaryOpFunc("-"), "return p1 - p2")
+	RegisterBuiltin("*", binaryOpFunc("*"), "return p1 * p2")
+	RegisterBuiltin("/", binaryOpFunc("/"), "return p1 / p2")
+	RegisterBuiltin("^", binaryOpFunc("^"), "return p1 ^ p2")
 	RegisterBuiltin("&", and, "return p1 & p2 (bitwise and)")
 	RegisterBuiltin("|", or, "return p1 | p2 (bitwise or)")
-	RegisterBuiltin("~", not, "return p1 | p2 (bitwise not)")
-	RegisterBuiltin("neg", neg, "return -p1 ")
+	RegisterBuiltin("~", not, "return ~p1 (bitwise not)")
+	RegisterBuiltin("neg", neg, "return -p1")
 	RegisterBuiltin("lsh", lsh, "return p1 << p2 (left shift)")
 	RegisterBuiltin("rsh", rsh, "return p1 >> p2 (right shift)")
 
 	/*** General functions ***/
-	RegisterBuiltin("binom", binom, "binmomial coeffient of (p1, p2)")
+	RegisterBuiltin("binom", binom, "binomial coefficient of (p1, p2)")
 	RegisterBuiltin("choose", binom, "p1 choose p2. Same as binom")
+	RegisterBuiltin("powmod", modPowFn, "return p1 ^ p2 modulo p3, using fast modular exponentiation")
+	RegisterBuiltin("invmod", modInverseFn, "return the inverse of p1 modulo p2")
 	RegisterBuiltin("bit", bit, "return the value of bit p2 in p1, counting from 0")
 	RegisterBuiltin("now", now, "return the number of milliseconds since epoch")
+	RegisterBuiltin("out", out, "return the result of input number p1 from the history; negative numbers count back from the latest")
+	RegisterBuiltin("env", env, "return the value of the environment variable named p1, as a number if it is one")
 	RegisterBuiltin("roll", roll, "roll p1 dice each having p2 sides and sum the outcomes")
 	RegisterBuiltin("bytes", getBytes, "return a list of each byte composing an integer")
 	RegisterBuiltin("if", conditional, "implements if/elsif/else")
@@ -393,8 +506,30 @@ func init() {
 	RegisterBuiltin("lrev", listReverse, "return a copy of list p1 with elements in reverse order")
 	RegisterBuiltin("lrp", listRepeat, "return a list consisting of p1 repeated p2 times")
 	RegisterBuiltin("unbytes", unbytes, "treat the list as a list of bytes and convert it to an integer")
+	RegisterBuiltin("hexdump", hexdump, "format the list of bytes p1 as a hex dump in the style of `hexdump -C`")
+	RegisterBuiltin("unhexdump", unhexdump, "parse the string p1 containing hexdump -C, xxd or gdb x/xb output into a list of bytes")
 	RegisterBuiltin("map", listMap, "return a new list which is the result of applying the function p2 to each element in p1")
 	RegisterBuiltin("reduce", listReduce, "apply a dyadic function p2 to each element in the list p1 and an accumulator (having initial value p3), returning the final value of the accumulator")
 	RegisterBuiltin("filter", listFilter, "apply a predicate function p2 to each element in the list p1, returning a list of the values for which it returned 'true' (that is, nonzero)")
+	/*** Network functions ***/
+	RegisterBuiltin("ipv4", ipv4, "return the IPv4 address having the integer value p1")
+	RegisterBuiltin("ipv6", ipv6, "return the IPv6 address having the integer value p1")
+	RegisterBuiltin("ipnum", ipNum, "return the integer value of the IP address p1")
+	RegisterBuiltin("cidr", ipCidr, "return the IP address p1 with the prefix length p2")
+	RegisterBuiltin("prefixlen", ipPrefixLen, "return the prefix length of the network p1")
+	RegisterBuiltin("network", ipNetwork, "return the network address of the network p1")
+	RegisterBuiltin("broadcast", ipBroadcast, "return the broadcast (last) address of the network p1")
+	RegisterBuiltin("netmask", ipNetmask, "return the netmask of the network p1")
+	RegisterBuiltin("hostcount", ipHostCount, "return the number of usable host addresses in the network p1")
+	RegisterBuiltin("contains", ipContains, "return 1 if the network p1 contains the address or network p2, 0 otherwise")
+	RegisterBuiltin("subnets", ipSubnets, "return a list of the subnets of network p1 having the prefix length p2")
+	RegisterBuiltin("mac", mac, "parse the MAC address string p1 into an integer")
+	RegisterBuiltin("macstr", macString, "format the integer p1 as a MAC address")
+	RegisterBuiltin("mac_to_eui64", macToEUI64, "convert the MAC address p1 to a modified EUI-64 interface identifier")
+	RegisterBuiltin("eui64_to_mac", eui64ToMAC, "convert the modified EUI-64 interface identifier p1 back to a MAC address")
+	RegisterBuiltin("ipv6_eui64", ipv6EUI64, "return the address in the IPv6 network p1 for the MAC address p2 using modified EUI-64")
 	registerStdlibMath()
+	registerHashes()
+	registerPoly()
+	documentBuiltins()
 }
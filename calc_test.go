@@ -1,8 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 var smallFloat = big.NewFloat(0.00001)
@@ -13,6 +22,12 @@ func teql(a, b interface{}) bool {
 		return listEql(a, b)
 	case BigFloatList:
 		return listEql(a, b)
+	case string:
+		bs, ok := b.(string)
+		return ok && t == bs
+	case IPAddr, IPAddrList:
+		bs, ok := b.(fmt.Stringer)
+		return ok && fmt.Sprint(t) == bs.String()
 	case []interface{}:
 		bl, ok := b.([]interface{})
 		if !ok {
@@ -80,6 +95,14 @@ func numEql(a, b interface{}) bool {
 	}
 }
 
+func mustParseIP(s string) IPAddr {
+	a, err := parseIPLiteral(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
 func strSliceEql(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -507,6 +530,31 @@ func TestCalc(t *testing.T) {
 			output: big.NewInt(85),
 		},
 
+		{
+			name:   "rsh_huge_count",
+			input:  "5>>(2^64)",
+			output: big.NewInt(0),
+		},
+		{
+			name:   "rsh_huge_count_negative",
+			input:  "-5>>(2^64)",
+			output: big.NewInt(-1),
+		},
+		{
+			name:   "bit",
+			input:  "bit(5, 2)",
+			output: big.NewInt(1),
+		},
+		{
+			name:   "bit_huge_index",
+			input:  "bit(5, 2^70)",
+			output: big.NewInt(0),
+		},
+		{
+			name:   "bit_huge_index_negative",
+			input:  "bit(-5, 2^70)",
+			output: big.NewInt(1),
+		},
 		{
 			name:   "bitwise_not",
 			input:  "~1",
@@ -755,6 +803,237 @@ func TestCalc(t *testing.T) {
 			input:  "if(0,4,0,2,5)",
 			output: big.NewInt(5),
 		},
+		{
+			name:   "hexdump",
+			input:  "hexdump([72,105,0,255])",
+			output: "00000000  48 69 00 ff                                       |Hi..|\n00000004",
+		},
+		{
+			name:   "unhexdump_hexdump",
+			input:  "unhexdump(\"00000000  48 69 00 ff                                       |Hi..|\")",
+			output: BigIntList{big.NewInt(72), big.NewInt(105), big.NewInt(0), big.NewInt(255)},
+		},
+		{
+			name:   "unhexdump_xxd",
+			input:  "unhexdump(\"00000000: 4869 00ff  Hi..;00000004: 0a  .\")",
+			output: BigIntList{big.NewInt(72), big.NewInt(105), big.NewInt(0), big.NewInt(255), big.NewInt(10)},
+		},
+		{
+			name:   "unhexdump_gdb",
+			input:  "unhexdump(\"0x601040 <buf>:\t0x48\t0x69\")",
+			output: BigIntList{big.NewInt(72), big.NewInt(105)},
+		},
+		{
+			name:   "unhexdump_invalid",
+			input:  "unhexdump(\"00000000: 48zz\")",
+			output: BigIntList{},
+			err:    true,
+		},
+		{
+			name:   "ipv4",
+			input:  "10.0.0.1",
+			output: mustParseIP("10.0.0.1"),
+		},
+		{
+			name:   "ipv6",
+			input:  "::1",
+			output: mustParseIP("::1"),
+		},
+		{
+			name:   "ip_add",
+			input:  "10.0.0.255 + 2",
+			output: mustParseIP("10.0.1.1"),
+		},
+		{
+			name:   "ip_sub_ip",
+			input:  "10.0.1.1 - 10.0.0.1",
+			output: big.NewInt(256),
+		},
+		{
+			name:   "ip_overflow",
+			input:  "255.255.255.255 + 1",
+			output: IPAddr{},
+			err:    true,
+		},
+		{
+			name:   "ip_network",
+			input:  "network(192.168.1.77/24)",
+			output: mustParseIP("192.168.1.0/24"),
+		},
+		{
+			name:   "ip_broadcast",
+			input:  "broadcast(192.168.1.77/24)",
+			output: mustParseIP("192.168.1.255/24"),
+		},
+		{
+			name:   "ip_netmask",
+			input:  "netmask(10.0.0.0/12)",
+			output: mustParseIP("255.240.0.0"),
+		},
+		{
+			name:   "ip_hostcount",
+			input:  "hostcount(10.0.0.0/24)",
+			output: big.NewInt(254),
+		},
+		{
+			name:   "ip_contains",
+			input:  "contains(10.0.0.0/8, 10.1.2.3)",
+			output: big.NewInt(1),
+		},
+		{
+			name:   "ip_subnets",
+			input:  "subnets(10.0.0.0/8, 9)",
+			output: IPAddrList{mustParseIP("10.0.0.0/9"), mustParseIP("10.128.0.0/9")},
+		},
+		{
+			name:   "ip_subnets_prefix_too_long",
+			input:  "subnets(10.0.0.0/8, 2^64 + 9)",
+			output: IPAddrList{},
+			err:    true,
+		},
+		{
+			name:   "ip_cidr_prefix_too_long",
+			input:  "cidr(10.0.0.0, 2^64 + 8)",
+			output: IPAddr{},
+			err:    true,
+		},
+		{
+			name:   "ip_list",
+			input:  "[10.0.0.1, 10.0.0.2]",
+			output: IPAddrList{mustParseIP("10.0.0.1"), mustParseIP("10.0.0.2")},
+		},
+		{
+			name:   "ip_list_map",
+			input:  "map([10.0.0.1, 10.0.0.2], def(a){a + 1})",
+			output: IPAddrList{mustParseIP("10.0.0.2"), mustParseIP("10.0.0.3")},
+		},
+		{
+			name:   "ip_list_filter",
+			input:  "filter(subnets(10.0.0.0/8, 10), def(a){contains(10.64.0.0/9, a)})",
+			output: IPAddrList{mustParseIP("10.0.0.0/10"), mustParseIP("10.64.0.0/10")},
+		},
+		{
+			name:   "ip_list_lrev",
+			input:  "lrev(subnets(10.0.0.0/8, 9))",
+			output: IPAddrList{mustParseIP("10.128.0.0/9"), mustParseIP("10.0.0.0/9")},
+		},
+		{
+			name:   "ip_incomplete",
+			input:  "1.2.3",
+			output: nil,
+			err:    true,
+		},
+		{
+			name:   "ipv6_letters_only",
+			input:  "a::b",
+			output: nil,
+			err:    true,
+		},
+		{
+			name:   "ipv6_leading_zero",
+			input:  "0a::b",
+			output: mustParseIP("a::b"),
+		},
+		{
+			name:   "ip_eui64",
+			input:  "ipv6_eui64(fe80::/64, mac(\"00:1a:2b:3c:4d:5e\"))",
+			output: mustParseIP("fe80::21a:2bff:fe3c:4d5e"),
+		},
+		{
+			name:   "crc32",
+			input:  "crc32([49,50,51,52,53,54,55,56,57])",
+			output: big.NewInt(0xcbf43926),
+		},
+		{
+			name:   "crc32c",
+			input:  "crc32c([49,50,51,52,53,54,55,56,57])",
+			output: big.NewInt(0xe3069283),
+		},
+		{
+			name:   "crc16_xmodem",
+			input:  "crc16([49,50,51,52,53,54,55,56,57], 0x1021)",
+			output: big.NewInt(0x31c3),
+		},
+		{
+			name:   "crc8",
+			input:  "crc8([49,50,51,52,53,54,55,56,57], 0x07)",
+			output: big.NewInt(0xf4),
+		},
+		{
+			name:   "crc_reflected",
+			input:  "crc([49,50,51,52,53,54,55,56,57], 16, 0x8005, 0, 1, 0)",
+			output: big.NewInt(0xbb3d),
+		},
+		{
+			name:   "adler32",
+			input:  "adler32([49,50,51,52,53,54,55,56,57])",
+			output: big.NewInt(0x091e01de),
+		},
+		{
+			name:  "sha1",
+			input: "sha1([97,98,99])",
+			output: func() *big.Int {
+				i, _ := big.NewInt(0).SetString("a9993e364706816aba3e25717850c26c9cd0d89d", 16)
+				return i
+			}(),
+		},
+		{
+			name:   "hash_not_bytes",
+			input:  "md5([256])",
+			output: (*big.Int)(nil),
+			err:    true,
+		},
+		{
+			name:   "luhn",
+			input:  "luhn(7992739871)",
+			output: big.NewInt(3),
+		},
+		{
+			name:   "isbn10",
+			input:  "isbn10(30640615)",
+			output: big.NewInt(2),
+		},
+		{
+			name:   "isbn13",
+			input:  "isbn13(978030640615)",
+			output: big.NewInt(7),
+		},
+		{
+			name:   "clmul",
+			input:  "clmul(0x57, 0x83)",
+			output: big.NewInt(0x2b79),
+		},
+		{
+			name:   "pmod",
+			input:  "pmod(0x2b79, 0x11b)",
+			output: big.NewInt(0xc1),
+		},
+		{
+			name:   "pdiv",
+			input:  "pdiv(0b1111, 0b11)",
+			output: big.NewInt(0b101),
+		},
+		{
+			name:   "pgcd",
+			input:  "pgcd(0b1111, 0b101)",
+			output: big.NewInt(0b101),
+		},
+		{
+			name:   "gfmul",
+			input:  "gfmul(0x57, 0x83, 0x11b)",
+			output: big.NewInt(0xc1),
+		},
+		{
+			name:   "gfinv",
+			input:  "gfinv(0x53, 0x11b)",
+			output: big.NewInt(0xca),
+		},
+		{
+			name:   "pmod_zero",
+			input:  "pmod(5, 0)",
+			output: (*big.Int)(nil),
+			err:    true,
+		},
 		{
 			name:   "closures_1",
 			input:  "def clamp(y) def(x){if(x>y,y,x)}; fn=clamp(3); fn(5); fn(2)",
@@ -815,6 +1094,80 @@ func TestUndefVarInOtherwiseValidExpr(t *testing.T) {
 	}
 }
 
+func TestDivisionByZero(t *testing.T) {
+	for _, input := range []string{"1/0", "1.5/0", "[1,2]/[1,0]", "/(1,0)"} {
+		_, err := Parse("test", []byte(input))
+		if err == nil {
+			t.Fatalf("no error when dividing by zero in '%s'", input)
+		}
+		var e ErrDivisionByZero
+		if !errors.As(firstParseErr(err), &e) {
+			t.Fatalf("error for '%s' is not ErrDivisionByZero: %v %T", input, err, firstParseErr(err))
+		}
+	}
+}
+
+func TestFailedOperandsAreNotUsed(t *testing.T) {
+	defer delete(GlobalVars, "nv_x")
+	defer delete(Funcs, "nv_f")
+
+	inputs := []string{
+		"nv_x = 5; nv_x = 1/0; nv_x",
+		"lrev([1/0, 2])",
+		"hexdump([1/0])",
+		"map([1/0], def(x){x})",
+		"def nv_f(a) a; nv_f(1/0)",
+	}
+	for _, in := range inputs {
+		_, err := Parse("test", []byte(in))
+		if err == nil {
+			t.Fatalf("%s: expected an error", in)
+		}
+		if msg := err.Error(); strings.Contains(msg, "nil pointer") || !strings.Contains(msg, "Division by zero") {
+			t.Fatalf("%s: expected only the division by zero but got %v", in, err)
+		}
+	}
+
+	if !teql(GlobalVars["nv_x"], big.NewInt(5)) {
+		t.Fatalf("expected the failed assignment to keep nv_x as 5 but it's %v", GlobalVars["nv_x"])
+	}
+}
+
+func TestIEEE(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"1.0/0", "+Inf"},
+		{"log(0.0)", "-Inf"},
+		{"sqrt(0.0-1)", "NaN"},
+		{"0.0/0", "NaN"},
+		{"log(0.0)-log(0.0)", "NaN"},
+		{"sqrt(0.0-1)+1", "NaN"},
+		{"sqrt(0.0-1)<1", "0"},
+	}
+
+	for _, tc := range tests {
+		_, err := Parse("test", []byte(tc.input))
+		if err == nil {
+			t.Fatalf("no error for '%s' when ieee is off", tc.input)
+		}
+	}
+
+	ieee = true
+	defer func() { ieee = false }()
+
+	for _, tc := range tests {
+		v, err := Parse("test", []byte(tc.input))
+		if err != nil {
+			t.Fatalf("parsing '%s' failed: %v", tc.input, err)
+		}
+		if s := fmt.Sprint(v); s != tc.output {
+			t.Fatalf("'%s': expected %s but got %s", tc.input, tc.output, s)
+		}
+	}
+}
+
 func TestTwoUndefVarInOtherwiseValidExpr(t *testing.T) {
 	_, err := Parse("test", []byte("1+X+y"))
 	if err == nil {
@@ -964,3 +1317,1417 @@ func TestFuncDef(t *testing.T) {
 	}
 
 }
+
+func TestFormatPoly(t *testing.T) {
+	tests := []struct {
+		n      int64
+		output string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{2, "x"},
+		{0x11b, "x^8 + x^4 + x^3 + x + 1"},
+	}
+
+	for _, tc := range tests {
+		if s := formatPoly(big.NewInt(tc.n)); s != tc.output {
+			t.Fatalf("formatting %#x: expected '%s' but got '%s'", tc.n, tc.output, s)
+		}
+	}
+}
+
+func TestModulus(t *testing.T) {
+	defer func() {
+		modulus.m = nil
+		modRep = canonicalRep
+	}()
+
+	tests := []struct {
+		name   string
+		input  string
+		output interface{}
+		err    bool
+	}{
+		{name: "sub", input: "5-10", output: big.NewInt(92)},
+		{name: "div", input: "3/4", output: big.NewInt(25)},
+		{name: "no_inverse", input: "1/0", output: (*big.Int)(nil), err: true},
+		{name: "exp", input: "2^100", output: big.NewInt(16)},
+		{name: "neg", input: "-3", output: big.NewInt(94)},
+		{name: "list", input: "[1,2,3]*[50,50,50]", output: BigIntList{big.NewInt(50), big.NewInt(3), big.NewInt(53)}},
+		{name: "func", input: "def sq1(x) x*x+1; sq1(20)", output: []interface{}{big.NewInt(13)}},
+		{name: "reduce", input: "reduce([50,50],+,0)", output: big.NewInt(3)},
+		{name: "float", input: "1.5+1", output: nil, err: true},
+		{name: "literal", input: "100+0", output: big.NewInt(3)},
+		{name: "variable", input: "mod_x = 200; mod_x*1", output: []interface{}{big.NewInt(6)}},
+		{name: "list_literal", input: "[100, 200]*1", output: BigIntList{big.NewInt(3), big.NewInt(6)}},
+		{name: "not_reduced", input: "100", output: big.NewInt(100)},
+		{name: "exp_paren", input: "3^(97)", output: big.NewInt(3)},
+		{name: "exp_variable", input: "mod_x = 97; 3^mod_x", output: []interface{}{big.NewInt(3)}},
+		{name: "func_args", input: "llen(lrp(1, 100))", output: big.NewInt(100)},
+		{name: "func_modulus_arg", input: "powmod(3, 97, 97)", output: big.NewInt(3)},
+		{name: "compare", input: "100 = 3", output: big.NewInt(1)},
+		{name: "compare_variable", input: "mod_x = 200; mod_x < 10", output: []interface{}{big.NewInt(1)}},
+		{name: "list_scalar", input: "[1,2,3]*50", output: BigIntList{big.NewInt(50), big.NewInt(3), big.NewInt(53)}},
+		{name: "scalar_list", input: "100-[1,2]", output: BigIntList{big.NewInt(2), big.NewInt(1)}},
+		{name: "func_result", input: "llen([1,2])*100", output: big.NewInt(6)},
+	}
+	defer delete(GlobalVars, "mod_x")
+
+	if err := SetSetting("modulus", "100-3"); err != nil {
+		t.Fatalf("setting modulus failed: %v", err)
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := Parse("test", []byte(tc.input))
+
+			if err != nil && !tc.err {
+				t.Fatalf("parsing '%s' failed: %v", tc.input, err)
+			}
+
+			if tc.err && err == nil {
+				t.Fatalf("expected error but none occurred")
+			}
+
+			if !teql(parsed, tc.output) {
+				t.Fatalf("expected '%v' (type %T) but got '%v' (type %T)", tc.output, tc.output, parsed, parsed)
+			}
+		})
+	}
+
+	if err := SetSetting("modrep", "sym"); err != nil {
+		t.Fatalf("setting representation failed: %v", err)
+	}
+	v, err := Parse("test", []byte("60+0"))
+	if err != nil || !numEql(v, big.NewInt(-37)) {
+		t.Fatalf("symmetric representation: expected -37 but got %v (error %v)", v, err)
+	}
+
+	symTests := []struct {
+		input  string
+		output interface{}
+	}{
+		{"60+0", big.NewInt(-37)},
+		{"mod_x = 60+0; mod_x", []interface{}{big.NewInt(-37)}},
+		{"[60, 1]*1", BigIntList{big.NewInt(-37), big.NewInt(1)}},
+		{"[1, 2]*30", BigIntList{big.NewInt(30), big.NewInt(-37)}},
+		{"~3", big.NewInt(-4)},
+		{"60 < 0", big.NewInt(1)},
+	}
+	for _, tc := range symTests {
+		v, err := Parse("test", []byte(tc.input))
+		if err != nil || !teql(v, tc.output) {
+			t.Fatalf("symmetric representation of %s: expected %v but got %v (error %v)", tc.input, tc.output, v, err)
+		}
+	}
+}
+
+func TestReportError(t *testing.T) {
+	SetGlobal("widget_count", big.NewInt(1))
+	defer delete(GlobalVars, "widget_count")
+
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "1+2*(3/0)",
+			output: "Error: 1:7: Division by zero\n  1+2*(3/0)\n        ^\n",
+		},
+		{
+			input:  "sqrt(1/0)",
+			output: "Error: 1:7: Division by zero\n  sqrt(1/0)\n        ^\n",
+		},
+		{
+			input:  "2*widget_cout",
+			output: "Error: 1:3: Unbound variable widget_cout. Did you mean widget_count?\n  2*widget_cout\n    ^\n",
+		},
+		{
+			input:  "sqrtt(4.0)",
+			output: "Error: 1:1: No such function sqrtt. Did you mean sqrt?\n  sqrtt(4.0)\n  ^\n",
+		},
+	}
+
+	for _, tc := range tests {
+		_, err := Parse("test", []byte(tc.input))
+		if err == nil {
+			t.Fatalf("no error for '%s'", tc.input)
+		}
+		var buf bytes.Buffer
+		reportError(&buf, tc.input, err)
+		if buf.String() != tc.output {
+			t.Fatalf("'%s': expected\n%s\nbut got\n%s", tc.input, tc.output, buf.String())
+		}
+	}
+}
+
+func TestCallTrace(t *testing.T) {
+	defer delete(Funcs, "tr_inner")
+	defer delete(Funcs, "tr_outer")
+
+	input := "def tr_inner(y) 1/(y-2);def tr_outer(x) tr_inner(x)+x;tr_outer(2)"
+	_, err := Parse("test", []byte(input))
+	if err == nil {
+		t.Fatalf("no error for '%s'", input)
+	}
+
+	var de ErrDivisionByZero
+	if !errors.As(rootError(err), &de) {
+		t.Fatalf("expected division by zero but got %v", err)
+	}
+
+	trace := callTrace(firstError(err))
+	if len(trace) != 2 {
+		t.Fatalf("expected 2 frames but got %d", len(trace))
+	}
+	if trace[0].Name != "tr_inner" || trace[0].Pos.col != 2 || formatValue(trace[0].Args[0]) != "2" {
+		t.Fatalf("unexpected innermost frame %+v", trace[0])
+	}
+	if trace[1].Name != "tr_outer" || trace[1].Pos.col != 1 {
+		t.Fatalf("unexpected outer frame %+v", trace[1])
+	}
+
+	// The caller's parameters must survive calls to other functions
+	r, err := Parse("test", []byte("def tr_inner(y) y*2;def tr_outer(x) tr_inner(x)+x;tr_outer(3)"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if l := r.([]interface{}); l[len(l)-1].(*big.Int).Int64() != 9 {
+		t.Fatalf("expected 9 but got %v", l[len(l)-1])
+	}
+
+	_, err = Parse("test", []byte("map([1,0], def(x){1/x})"))
+	trace = callTrace(firstError(err))
+	if len(trace) != 2 || trace[0].Name != "lambda@1:12" || trace[1].Name != "map" {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := EvalContext(ctx, "test", []byte("def f(x) x*2;f(3)+1"))
+	if err != ErrInterrupted {
+		t.Fatalf("expected ErrInterrupted but got %v", err)
+	}
+
+	if err := SetSetting("timeout", "1ms"); err != nil {
+		t.Fatalf("setting timeout failed: %v", err)
+	}
+	defer SetSetting("timeout", "off")
+	_, err = EvalContext(context.Background(), "test", []byte("7^(10^7)"))
+	if _, ok := err.(ErrTimeout); !ok {
+		t.Fatalf("expected ErrTimeout but got %v", err)
+	}
+
+	SetSetting("timeout", "off")
+	r, err := EvalContext(context.Background(), "test", []byte("3^5"))
+	if err != nil || r.(*big.Int).Int64() != 243 {
+		t.Fatalf("expected 243 but got %v, %v", r, err)
+	}
+
+	if err := SetSetting("timeout", "soon"); err == nil {
+		t.Fatalf("expected error for invalid timeout")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	defer SetLimits(DefaultLimits)
+	defer delete(Funcs, "lim_f")
+
+	tests := []struct {
+		input   string
+		setting string
+	}{
+		{"2^(2^40)", "maxintbits"},
+		{"[2,3]^[5,2^40]", "maxintbits"},
+		{"1<<(2^40)", "maxintbits"},
+		{"(2^(2^26))*(2^(2^26))", "maxintbits"},
+		{"binom(2^40, 2^39)", "maxintbits"},
+		{"lrp(1, 10^12)", "maxlistlen"},
+		{"def lim_f(n) n;def lim_f(n) if(n, lim_f(n-1), 0);lim_f(5)", "maxdepth"},
+	}
+
+	for _, tc := range tests {
+		_, err := EvalContext(context.Background(), "test", []byte(tc.input))
+		var le ErrLimitExceeded
+		if !errors.As(rootError(err), &le) || le.Setting != tc.setting {
+			t.Fatalf("'%s': expected %s to be exceeded but got %v", tc.input, tc.setting, err)
+		}
+	}
+
+	SetLimits(Limits{MaxSteps: 5})
+	_, err := EvalContext(context.Background(), "test", []byte("1+1+1+1+1+1+1"))
+	if _, ok := rootError(err).(ErrLimitExceeded); !ok {
+		t.Fatalf("expected the step limit to be exceeded but got %v", err)
+	}
+	if _, err := EvalContext(context.Background(), "test", []byte("1+1+1")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	SetLimits(DefaultLimits)
+	if err := SetSetting("maxintbits", "100"); err != nil {
+		t.Fatalf("setting maxintbits failed: %v", err)
+	}
+	if CurrentLimits().MaxIntBits != 100 {
+		t.Fatalf("setting maxintbits didn't change the limit")
+	}
+	if _, err := EvalContext(context.Background(), "test", []byte("2^99")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := EvalContext(context.Background(), "test", []byte("3^99")); err == nil {
+		t.Fatalf("expected error for 3^99")
+	}
+}
+
+func TestLimitsInContext(t *testing.T) {
+	ctx := WithLimits(context.Background(), Limits{MaxIntBits: 100})
+	if _, err := EvalContext(ctx, "test", []byte("3^99")); err == nil {
+		t.Fatalf("expected the limits of the context to apply to 3^99")
+	}
+	if CurrentLimits() != DefaultLimits {
+		t.Fatalf("the limits of the context changed those of the session")
+	}
+	if _, err := EvalContext(context.Background(), "test", []byte("3^99")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestPolyInterrupt(t *testing.T) {
+	exprs := []string{"clmul(2^(2^20), 2^(2^20)-1)", "pmod(2^(2^20)-1, 3)"}
+	for _, expr := range exprs {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		_, err := EvalContext(ctx, "test", []byte(expr))
+		cancel()
+		if err != ErrInterrupted || time.Since(start) > time.Second {
+			t.Fatalf("%s: expected to be interrupted but got %v after %v", expr, err, time.Since(start))
+		}
+
+		ctx = WithLimits(context.Background(), Limits{MaxSteps: 1000})
+		_, err = EvalContext(ctx, "test", []byte(expr))
+		if _, ok := rootError(err).(ErrLimitExceeded); !ok {
+			t.Fatalf("%s: expected the step limit to be exceeded but got %v", expr, err)
+		}
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	defer delete(GlobalVars, "scr_x")
+	defer delete(Funcs, "scr_sq")
+
+	script := `#!/usr/bin/calc -f
+# Comments may be on their own line
+scr_x = 2 + \
+    3        // or at the end of one
+def scr_sq(a) a*a   # square
+[1,
+ 2,
+ scr_sq(scr_x)]
+"a#b"
+bad(
+  1,
+  2/0)
+scr_x*2
+`
+	var results []interface{}
+	var errs bytes.Buffer
+	failed := RunScript("test.calc", strings.NewReader(script), &errs, func(r interface{}) {
+		results = append(results, r)
+	}, true)
+
+	if failed != 1 {
+		t.Fatalf("expected 1 failure but got %d", failed)
+	}
+
+	expected := []interface{}{nil, nil, BigIntList{big.NewInt(1), big.NewInt(2), big.NewInt(25)}, "a#b", big.NewInt(10)}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results but got %d: %v", len(expected), len(results), results)
+	}
+	for i := range expected {
+		if expected[i] == nil {
+			continue
+		}
+		if !teql(results[i], expected[i]) {
+			t.Fatalf("result %d: expected %v but got %v", i, expected[i], results[i])
+		}
+	}
+
+	out := errs.String()
+	if !strings.Contains(out, "Error: test.calc:12:4: Division by zero\n    2/0)\n     ^\n") {
+		t.Fatalf("unexpected error output:\n%s", out)
+	}
+}
+
+func TestRunScriptStopsOnError(t *testing.T) {
+	script := "1+1\n1/0\n2+2\n"
+
+	var results []interface{}
+	var errs bytes.Buffer
+	result := func(r interface{}) {
+		results = append(results, r)
+	}
+
+	if failed := RunScript("", strings.NewReader(script), &errs, result, false); failed != 1 || len(results) != 1 {
+		t.Fatalf("expected the script to stop after the error, but got %d failures and results %v", failed, results)
+	}
+
+	results = nil
+	if failed := RunScript("", strings.NewReader(script), &errs, result, true); failed != 1 || len(results) != 2 {
+		t.Fatalf("expected the script to keep going, but got %d failures and results %v", failed, results)
+	}
+}
+
+func TestScriptArgs(t *testing.T) {
+	defer func() {
+		for _, n := range []string{"$1", "$2", "$3", "argc", "argv"} {
+			delete(GlobalVars, n)
+		}
+	}()
+
+	if err := SetScriptArgs([]string{"1", "0x10", "-3"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	tests := []struct {
+		input  string
+		output interface{}
+	}{
+		{"$1+$2", big.NewInt(17)},
+		{"$3", big.NewInt(-3)},
+		{"argc", big.NewInt(3)},
+		{"argv", BigIntList{big.NewInt(1), big.NewInt(16), big.NewInt(-3)}},
+	}
+	for _, tc := range tests {
+		r, err := Parse("test", []byte(tc.input))
+		if err != nil || !teql(r, tc.output) {
+			t.Fatalf("'%s': expected %v but got %v, %v", tc.input, tc.output, r, err)
+		}
+	}
+
+	if err := SetScriptArgs([]string{"2", "0.5"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, ok := GlobalVars["argv"].(BigFloatList); !ok {
+		t.Fatalf("expected argv to be a list of floats but got %v", GlobalVars["argv"])
+	}
+
+	if err := SetScriptArgs([]string{"2", "x"}); err == nil {
+		t.Fatalf("expected an error for an argument that isn't a number")
+	}
+}
+
+func TestEnv(t *testing.T) {
+	os.Setenv("CALC_TEST_NUM", "8080")
+	os.Setenv("CALC_TEST_STR", "hello")
+	defer os.Unsetenv("CALC_TEST_NUM")
+	defer os.Unsetenv("CALC_TEST_STR")
+
+	r, err := Parse("test", []byte(`env("CALC_TEST_NUM")+1`))
+	if err != nil || !teql(r, big.NewInt(8081)) {
+		t.Fatalf("expected 8081 but got %v, %v", r, err)
+	}
+	r, err = Parse("test", []byte(`env("CALC_TEST_STR")`))
+	if err != nil || r != "hello" {
+		t.Fatalf("expected hello but got %v, %v", r, err)
+	}
+	if _, err = Parse("test", []byte(`env("CALC_TEST_UNSET")`)); err == nil {
+		t.Fatalf("expected an error for an unset variable")
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	var buf bytes.Buffer
+	jsonWriter = &buf
+	jsonOutput = true
+	defer func() {
+		jsonWriter = os.Stdout
+		jsonOutput = false
+	}()
+
+	script := "2^100\n[1.5, 2.0]\nx_json = 1\n(1 +\n  1/0)\n"
+	RunScript("", strings.NewReader(script), ioutil.Discard, nil, true)
+
+	expected := []string{
+		`{"input":"2^100","type":"int","value":"1267650600228229401496703205376","display":"1267650600228229401496703205376"}`,
+		`{"input":"[1.5, 2.0]","type":"float list","value":["1.5","2"],"display":"[1.5, 2]"}`,
+		`{"input":"x_json = 1"}`,
+		`{"input":"(1 +\n  1/0)","error":{"message":"Division by zero","line":5,"column":4}}`,
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines but got:\n%s", len(expected), buf.String())
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Fatalf("expected\n%s\nbut got\n%s", expected[i], lines[i])
+		}
+	}
+	delete(GlobalVars, "x_json")
+}
+
+func TestJSONOutputOnlyResults(t *testing.T) {
+	var buf bytes.Buffer
+	jsonWriter = &buf
+	jsonOutput = true
+	defer func() {
+		jsonWriter = os.Stdout
+		jsonOutput = false
+		delete(GlobalVars, "rc_json")
+	}()
+
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/.calcrc", []byte("rc_json = 5\n"), 0600)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", dir)
+
+	LoadInitScript()
+	if buf.Len() != 0 || !jsonOutput {
+		t.Fatalf("expected the init script to write no JSON but got %q", buf.String())
+	}
+
+	// The text of statements such as vars goes to stderr
+	stdout, stderr := os.Stdout, os.Stderr
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+	out, err := os.Create(dir + "/stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errOut, err := os.Create(dir + "/stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout, os.Stderr = out, errOut
+	RunScript("", strings.NewReader("vars\nshow rc_json\nhistory\nhelp sqrt\n"), ioutil.Discard, nil, true)
+	os.Stdout, os.Stderr = stdout, stderr
+	out.Close()
+	errOut.Close()
+
+	if b, _ := ioutil.ReadFile(dir + "/stdout"); len(b) != 0 {
+		t.Fatalf("expected nothing on stdout but got %q", b)
+	}
+	if b, _ := ioutil.ReadFile(dir + "/stderr"); !strings.Contains(string(b), "rc_json = 5") {
+		t.Fatalf("expected the variables on stderr but got %q", b)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 4 {
+		t.Fatalf("expected a JSON object for each line but got %q", buf.String())
+	}
+}
+
+func TestSettingsRegistry(t *testing.T) {
+	for _, k := range settingNames() {
+		s := Settings[k]
+		if s.name != k || s.help == "" || s.Allowed() == "" {
+			t.Fatalf("setting %s isn't described: %+v", k, s)
+		}
+	}
+	if s := Settings["obase"]; s.def != "dec" || s.Allowed() != "hex, dec, bin, poly" {
+		t.Fatalf("unexpected obase setting: default %q, values %q", s.def, s.Allowed())
+	}
+
+	defer SetSetting("obase", "dec")
+	defer SetSetting("modulus", "off")
+	if _, err := Parse("test", []byte("1; set obase hex ; set modulus 2^61 - 1")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if outputBase != hexBase || modulus.String() != "2305843009213693951" {
+		t.Fatalf("unexpected settings %v and %v", outputBase, modulus)
+	}
+	if _, err := Parse("test", []byte(`set obase default; set modulus "off"`)); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if outputBase != decimalBase || modulus.active() {
+		t.Fatalf("unexpected settings %v and %v", outputBase, modulus)
+	}
+
+	for v, expected := range map[string]string{"hex": "hex", "": `""`, "a b": `"a b"`, "x;y": `"x;y"`, `a"b\c`: `"a\"b\\c"`} {
+		if l := settingLiteral(v); l != expected {
+			t.Fatalf("literal of %q: expected %s but got %s", v, expected, l)
+		}
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	defer SetSetting("prompt", "default")
+	defer SetSetting("modulus", "off")
+	defer SetSetting("modrep", "default")
+	defer func(e []historyEntry) { entries = e }(entries)
+	entries = make([]historyEntry, 11)
+
+	if p := prompt.expand(); p != "> " {
+		t.Fatalf("expected the default prompt but got %q", p)
+	}
+	SetSetting("modulus", "97")
+	SetSetting("modrep", "sym")
+	if p := prompt.expand(); p != "[mod 97 sym] > " {
+		t.Fatalf("expected the modulus in the prompt but got %q", p)
+	}
+
+	if _, err := Parse("test", []byte(`set prompt "{{{obase}}} {n}: "`)); err != nil {
+		t.Fatalf("setting the prompt failed: %v", err)
+	}
+	if p := prompt.expand(); p != "{dec} 12: " {
+		t.Fatalf("unexpected prompt %q", p)
+	}
+
+	for _, tmpl := range []string{"{width}> ", "{obase> ", "}> "} {
+		if err := SetSetting("prompt", tmpl); err == nil {
+			t.Fatalf("%q: expected an error", tmpl)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/config"
+
+	config := `# calc configuration
+obase = hex
+maxdepth 100
+
+ieee=on
+nosuch = 1
+histsize = many
+`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer SetSetting("obase", "default")
+	defer SetSetting("maxdepth", "default")
+	defer SetSetting("ieee", "default")
+
+	err = LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), path+":6: No such setting nosuch") || !strings.Contains(err.Error(), path+":7: ") {
+		t.Fatalf("expected errors for lines 6 and 7 but got %v", err)
+	}
+	if outputBase != hexBase || limits.MaxDepth != 100 || !ieee {
+		t.Fatalf("the valid settings weren't changed: %v, %v, %v", outputBase, limits.MaxDepth, ieee)
+	}
+	if err := LoadConfig(dir + "/missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected a not-exist error but got %v", err)
+	}
+
+	// Values with quotes and backslashes are read back as they were written, from the
+	// configuration file and from a set statement as in a saved session
+	defer SetSetting("prompt", "default")
+	p := `say "hi" \ {n}> `
+	if err := ioutil.WriteFile(path, []byte("prompt = "+settingLiteral(p)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(path); err != nil || prompt.String() != p {
+		t.Fatalf("expected the prompt %q but got %q (error %v)", p, prompt.String(), err)
+	}
+	SetSetting("prompt", "default")
+	if _, err := Parse("test", []byte("set prompt "+settingLiteral(p))); err != nil || prompt.String() != p {
+		t.Fatalf("expected the prompt %q but got %q (error %v)", p, prompt.String(), err)
+	}
+}
+
+func TestSaveLoadSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/session.calc"
+
+	script := `def sess_sq(a) "square p1" a*a
+def sess_f(a) sess_sq(a)+1
+sess_i = -12
+sess_fl = 2.5
+sess_l = [1, 2, 3]
+sess_s = "a string"
+sess_g = def(a, b) {a+b}
+sess_h = sess_sq
+set obase bin
+save "` + path + `"`
+	if failed := RunScript("", strings.NewReader(script), ioutil.Discard, nil, false); failed > 0 {
+		t.Fatalf("%d lines of the script failed", failed)
+	}
+
+	names := []string{"sess_i", "sess_fl", "sess_l", "sess_s", "sess_g", "sess_h"}
+	for _, n := range names {
+		delete(GlobalVars, n)
+	}
+	delete(Funcs, "sess_sq")
+	delete(Funcs, "sess_f")
+	outputBase = decimalBase
+
+	if _, err := Parse("test", []byte("load "+path)); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer func() {
+		for _, n := range names {
+			delete(GlobalVars, n)
+		}
+		delete(Funcs, "sess_sq")
+		delete(Funcs, "sess_f")
+		outputBase = decimalBase
+	}()
+
+	if outputBase != binaryBase {
+		t.Fatalf("expected obase to be restored")
+	}
+	if f, ok := Funcs["sess_sq"]; !ok || f.Help() != "square p1" {
+		t.Fatalf("expected sess_sq to be restored with its help")
+	}
+
+	tests := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{"sess_f(3)", big.NewInt(10)},
+		{"sess_i", big.NewInt(-12)},
+		{"sess_fl", big.NewFloat(2.5)},
+		{"sess_l", BigIntList{big.NewInt(1), big.NewInt(2), big.NewInt(3)}},
+		{"sess_s", "a string"},
+		{"sess_g(1, 2)", big.NewInt(3)},
+		{"sess_h(4)", big.NewInt(16)},
+	}
+	for _, tc := range tests {
+		r, err := Parse("test", []byte(tc.expr))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if !teql(r, tc.expected) {
+			t.Fatalf("%s: expected %v but got %v", tc.expr, tc.expected, r)
+		}
+	}
+}
+
+func TestSaveLoadStrings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/session.calc"
+
+	names := []string{"sess_hd", "sess_q", "sess_c"}
+	defer func() {
+		for _, n := range names {
+			delete(GlobalVars, n)
+		}
+		delete(Funcs, "sess_mk")
+	}()
+
+	script := `sess_hd = hexdump([104, 101, 108, 108, 111, 10, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17])
+sess_q = "a \"quoted\" C:\\path\tand\\n"
+def sess_mk(a) def(b){a+b}
+sess_c = sess_mk(1)
+save "` + path + `"`
+	if failed := RunScript("", strings.NewReader(script), ioutil.Discard, nil, false); failed > 0 {
+		t.Fatalf("%d lines of the script failed", failed)
+	}
+	hd, q := GlobalVars["sess_hd"], GlobalVars["sess_q"]
+	if q != "a \"quoted\" C:\\path\tand\\n" {
+		t.Fatalf("unexpected string %q", q)
+	}
+	for _, n := range names {
+		delete(GlobalVars, n)
+	}
+
+	if _, err := Parse("test", []byte("load "+path)); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if GlobalVars["sess_hd"] != hd || GlobalVars["sess_q"] != q {
+		t.Fatalf("expected %q and %q but got %q and %q", hd, q, GlobalVars["sess_hd"], GlobalVars["sess_q"])
+	}
+	saved, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(saved), "# sess_c: can't be saved") {
+		t.Fatalf("expected a comment for the value that can't be saved:\n%s", saved)
+	}
+}
+
+func TestInputHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(n int) { historySize = n }(historySize)
+	historySize = 3
+
+	h, err := loadInputHistory(dir + "/history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{"1", "2", "1", "3", "4"} {
+		if err := h.add(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err = loadInputHistory(dir + "/history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(h.lines, " ") != "1 3 4" {
+		t.Fatalf("expected history 1 3 4 but got %v", h.lines)
+	}
+}
+
+func TestNumberedResults(t *testing.T) {
+	defer func() {
+		entries = nil
+		for k := range GlobalVars {
+			if isResultName(k) || strings.HasPrefix(k, "$") {
+				delete(GlobalVars, k)
+			}
+		}
+	}()
+
+	for _, in := range []string{"2^10", "x_hist = 3", "1/0", "_1 * x_hist"} {
+		r, err := Parse("test", []byte(in))
+		recordEntry(in, r, err)
+	}
+	delete(GlobalVars, "x_hist")
+
+	tests := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{"_1", big.NewInt(1024)},
+		{"$4", big.NewInt(3072)},
+		{"out(4)", big.NewInt(3072)},
+		{"out(-4)", big.NewInt(1024)},
+	}
+	for _, tc := range tests {
+		r, err := Parse("test", []byte(tc.expr))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if !teql(r, tc.expected) {
+			t.Fatalf("%s: expected %v but got %v", tc.expr, tc.expected, r)
+		}
+	}
+
+	for _, expr := range []string{"out(2)", "out(3)", "out(5)", "out(0)"} {
+		if _, err := Parse("test", []byte(expr)); err == nil {
+			t.Fatalf("%s: expected an error", expr)
+		}
+	}
+}
+
+func TestNumberedResultsLimit(t *testing.T) {
+	defer func(n int) {
+		historySize = n
+		entries = nil
+		for k := range GlobalVars {
+			if isResultName(k) || strings.HasPrefix(k, "$") {
+				delete(GlobalVars, k)
+			}
+		}
+	}(historySize)
+	historySize = 2
+
+	for _, in := range []string{"1", "2", "3"} {
+		r, err := Parse("test", []byte(in))
+		recordEntry(in, r, err)
+	}
+
+	if _, ok := GlobalVars["_1"]; ok {
+		t.Fatalf("expected _1 to be dropped")
+	}
+	if _, ok := GlobalVars["$1"]; ok {
+		t.Fatalf("expected $1 to be dropped")
+	}
+	if _, err := Parse("test", []byte("out(1)")); err == nil {
+		t.Fatalf("out(1): expected an error")
+	}
+	r, err := Parse("test", []byte("_2 + _3"))
+	if err != nil || !teql(r, big.NewInt(5)) {
+		t.Fatalf("_2 + _3: expected 5 but got %v (error %v)", r, err)
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	vars := map[string]interface{}{}
+	for k, v := range GlobalVars {
+		vars[k] = v
+	}
+	funcs := map[string]Func{}
+	for k, f := range Funcs {
+		funcs[k] = f
+	}
+	defer func() {
+		GlobalVars, Funcs = vars, funcs
+	}()
+	resetWorkspace()
+
+	for _, in := range []string{`def ws_sq(a) "square p1" a*a`, "ws_x = 3", "ws_g = def(a) {a+ws_x}", "ws_h = ws_sq"} {
+		if _, err := Parse("test", []byte(in)); err != nil {
+			t.Fatalf("%s: %v", in, err)
+		}
+	}
+
+	if s := funcSource(Funcs["ws_sq"]); s != `def ws_sq(a) "square p1" a*a` {
+		t.Fatalf("unexpected source %s", s)
+	}
+	if s := displayValue(GlobalVars["ws_g"]); s != "def(a) {a+ws_x}" {
+		t.Fatalf("unexpected lambda %s", s)
+	}
+	if s := displayValue(GlobalVars["ws_h"]); s != "ws_sq" {
+		t.Fatalf("unexpected function value %s", s)
+	}
+	if names := strings.Join(userVarNames(), " "); names != "ws_g ws_h ws_x" {
+		t.Fatalf("unexpected variables %s", names)
+	}
+
+	if _, err := Parse("test", []byte("undef ws_x ws_sq")); err != nil {
+		t.Fatalf("undef failed: %v", err)
+	}
+	if _, ok := GlobalVars["ws_x"]; ok {
+		t.Fatalf("expected ws_x to be undefined")
+	}
+	if _, ok := Funcs["ws_sq"]; ok {
+		t.Fatalf("expected ws_sq to be undefined")
+	}
+
+	for _, in := range []string{"undef sqrt", "undef ws_nope", "show ws_nope"} {
+		if _, err := Parse("test", []byte(in)); err == nil {
+			t.Fatalf("%s: expected an error", in)
+		}
+	}
+
+	if _, err := Parse("test", []byte("reset")); err != nil {
+		t.Fatalf("reset failed: %v", err)
+	}
+	if len(GlobalVars) != 0 || len(userFuncs()) != 0 {
+		t.Fatalf("expected reset to delete the variables and functions")
+	}
+	if _, ok := Funcs["sqrt"]; !ok {
+		t.Fatalf("expected reset to keep the builtins")
+	}
+}
+
+func TestBuiltinDocs(t *testing.T) {
+	defer func(b numberBase) { outputBase = b }(outputBase)
+
+	for _, c := range builtinCategories {
+		for _, d := range c.docs {
+			f, ok := Funcs[d.name].(*BuiltinFunc)
+			if !ok {
+				t.Fatalf("%s is documented but isn't a builtin", d.name)
+			}
+			if !f.typ.IsVariadic() && len(f.params) != f.typ.NumIn() {
+				t.Fatalf("%s has %d parameters but %d are documented", d.name, f.typ.NumIn(), len(f.params))
+			}
+			if d.result == "" {
+				continue
+			}
+
+			// The result may be written in hex
+			outputBase = decimalBase
+			if strings.HasPrefix(d.result, "0x") {
+				outputBase = hexBase
+			}
+			r, err := Parse("test", []byte(d.example))
+			if err != nil {
+				t.Fatalf("example %s failed: %v", d.example, err)
+			}
+			if s := formatValue(r); s != d.result {
+				t.Fatalf("example %s gives %s but is documented as %s", d.example, s, d.result)
+			}
+		}
+	}
+}
+
+func TestHelpText(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		help      string
+	}{
+		{"lsh", "lsh(a any, n any)", "return a << n (left shift)"},
+		{"hypot", "hypot(x float, y float)", "calculates sqrt(x*x + y*y). This function only has the precision of a float64."},
+		{"map", "map(l any, f function)", "return a new list which is the result of applying the function f to each element in l"},
+		{"if", "if(cond any, then any, ... any)", "implements if/elsif/else"},
+	}
+	for _, tc := range tests {
+		f := Funcs[tc.name]
+		if s := signature(tc.name, f, true); s != tc.signature {
+			t.Fatalf("expected signature %s but got %s", tc.signature, s)
+		}
+		if s := helpText(f); s != tc.help {
+			t.Fatalf("expected help %s but got %s", tc.help, s)
+		}
+	}
+
+	f := &DefinedFunc{name: "hyp", paramNames: []string{"a", "b"}, help: "hypotenuse of p1 and p2"}
+	if s := helpText(f); s != "hypotenuse of a and b" {
+		t.Fatalf("unexpected help %s", s)
+	}
+}
+
+func TestCompleter(t *testing.T) {
+	SetGlobal("cmp_var", big.NewInt(1))
+	defer undef("cmp_var")
+	// The completer only sees the names defined when the snapshot was taken
+	takeNameSnapshot()
+
+	var hint bytes.Buffer
+	c := &completer{hint: &hint}
+
+	tests := []struct {
+		line     string
+		pos      int
+		expected []string
+	}{
+		{"1 + cmp_v", -1, []string{"ar"}},
+		{"2 * hypo", -1, []string{"t("}},
+		{"sqt(2.0)", 2, []string{"r"}},
+		{"set oba", -1, []string{"se "}},
+		{"set obase ", -1, []string{"hex", "dec", "bin", "poly"}},
+		{"set modrep s", -1, []string{"ymmetric"}},
+		{"1; undef cmp_", -1, []string{"var"}},
+		{"his", -1, []string{"tory "}},
+		{"x = his", -1, nil},
+	}
+	for _, tc := range tests {
+		pos := tc.pos
+		if pos < 0 {
+			pos = len(tc.line)
+		}
+		l, _ := c.Do([]rune(tc.line), pos)
+		var got []string
+		for _, r := range l {
+			got = append(got, string(r))
+		}
+		if strings.Join(got, "|") != strings.Join(tc.expected, "|") {
+			t.Fatalf("%q: expected %v but got %v", tc.line, tc.expected, got)
+		}
+	}
+
+	if l, _ := c.Do([]rune("sqrt(2 * hypot("), 15); l != nil || !strings.HasPrefix(hint.String(), "hypot(x float, y float)") {
+		t.Fatalf("expected the signature of hypot but got %v, %q", l, hint.String())
+	}
+
+	undef("cmp_var")
+	takeNameSnapshot()
+	if l, _ := c.Do([]rune("cmp_v"), 5); l != nil {
+		t.Fatalf("expected no completions after undef but got %v", l)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []tokenKind
+	}{
+		{"1 + 2.5", []tokenKind{tokNumber, tokOperator, tokNumber}},
+		{"sqrt(x) # root", []tokenKind{tokName, tokBracket, tokName, tokBracket, tokComment}},
+		{"a << 10.0.0.1", []tokenKind{tokName, tokOperator, tokNumber}},
+		{`mac("00:11`, []tokenKind{tokName, tokBracket, tokString}},
+		{"[1, 2]; @", []tokenKind{tokBracket, tokNumber, tokOperator, tokNumber, tokBracket, tokOperator, tokOther}},
+	}
+	for _, tc := range tests {
+		var got []tokenKind
+		for _, tok := range tokenize(tc.line) {
+			got = append(got, tok.kind)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.expected) {
+			t.Fatalf("%q: expected %v but got %v", tc.line, tc.expected, got)
+		}
+	}
+}
+
+func TestNamesWhileEvaluating(t *testing.T) {
+	const n = 100
+	defer func(on boolSetting, scheme string) {
+		colorOutput = on
+		colors.Set(scheme)
+		for i := 0; i < n; i++ {
+			undef(fmt.Sprintf("rc_v%d", i))
+		}
+		takeNameSnapshot()
+		takeColorSnapshot()
+	}(colorOutput, colors.String())
+	colorOutput = true
+	takeColorSnapshot()
+	var buf bytes.Buffer
+	explainWriter = &buf
+	defer func() { explainWriter = textOut }()
+
+	// Readline paints and completes the line on its own goroutine while lines are
+	// evaluated. Run with -race to check that they don't share the maps of names.
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		h := &highlighter{}
+		c := &completer{}
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			h.Paint([]rune("1 + rc_v1"), 9)
+			h.Paint([]rune(`llen("abc")`), 0)
+			c.Do([]rune("rc_v"), 4)
+			c.Do([]rune("hypot("), 6)
+		}
+	}()
+	for i := 0; i < n; i++ {
+		Parse("test", []byte(fmt.Sprintf("rc_v%d = %d", i, i)))
+		Parse("test", []byte(`explain llen("abc") + 1`))
+		SetSetting("colors", fmt.Sprintf("number=%d", 30+i%8))
+		takeNameSnapshot()
+		takeColorSnapshot()
+	}
+	close(done)
+	<-stopped
+}
+
+func TestHighlighter(t *testing.T) {
+	SetGlobal("hl_var", big.NewInt(1))
+	defer undef("hl_var")
+	takeNameSnapshot()
+	takeColorSnapshot()
+	defer func(on boolSetting) { colorOutput = on }(colorOutput)
+	colorOutput = true
+
+	c := func(class, text string) string {
+		return "\x1b[" + colors[class] + "m" + text + "\x1b[0m"
+	}
+	h := &highlighter{}
+	tests := []struct {
+		pending  string
+		line     string
+		pos      int
+		expected string
+	}{
+		{"", "1 + hl_var", 0, c("number", "1") + " " + c("operator", "+") + " " + c("variable", "hl_var")},
+		{"", "sqrt(y", 0, c("function", "sqrt") + c("error", "(") + c("unknown", "y")},
+		{"", "z = \"s\"", 0, c("variable", "z") + " " + c("operator", "=") + " " + c("string", `"s"`)},
+		{"", "def hl_f(a) a*b", 0, c("keyword", "def") + " " + c("function", "hl_f") + "(" + c("variable", "a") + ") " +
+			c("variable", "a") + c("operator", "*") + c("unknown", "b")},
+		{"", "set obase hex", 0, c("keyword", "set") + " obase hex"},
+		{"", "time hl_var", 0, c("keyword", "time") + " " + c("variable", "hl_var")},
+		{"", "time + hl_var", 0, c("unknown", "time") + " " + c("operator", "+") + " " + c("variable", "hl_var")},
+		{"", "(1))", 1, c("match", "(") + c("number", "1") + c("match", ")") + c("error", ")")},
+		{"", "[1", 2, c("error", "[") + c("number", "1")},
+		{"max([1,", "2])", 2, c("number", "2") + c("match", "]") + ")"},
+	}
+	for _, tc := range tests {
+		h.pending = tc.pending
+		if got := string(h.Paint([]rune(tc.line), tc.pos)); got != tc.expected {
+			t.Fatalf("%q: expected %q but got %q", tc.line, tc.expected, got)
+		}
+	}
+
+	colorOutput = false
+	if got := string(h.Paint([]rune("1 + 2"), 0)); got != "1 + 2" {
+		t.Fatalf("expected no colours with color off but got %q", got)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	defer undef("pv_x")
+	defer func(b numberBase) { outputBase = b }(outputBase)
+	outputBase = decimalBase
+
+	tests := []struct {
+		line     string
+		expected string
+		isErr    bool
+	}{
+		{"2 ^ 10 + 1", "= 1025", false},
+		{"2 ^", "", false},
+		{"sqrt(2.0", "", false},
+		{"1 / 0", "Error: Division by zero", true},
+		{"pv_x = 3", "", false},
+		{"def pv_f(a) a", "", false},
+		{"set obase hex", "", false},
+		{"vars", "", false},
+		{"def(a){a * 2}", "= def(a) {a * 2}", false},
+		{"1; 2", "", false},
+		{"  ", "", false},
+	}
+	for _, tc := range tests {
+		text, isErr := previewLine(tc.line)
+		if text != tc.expected || isErr != tc.isErr {
+			t.Fatalf("%q: expected %q, %v but got %q, %v", tc.line, tc.expected, tc.isErr, text, isErr)
+		}
+	}
+	if _, ok := GlobalVars["pv_x"]; ok {
+		t.Fatalf("the preview assigned a variable")
+	}
+	if _, ok := Funcs["pv_f"]; ok {
+		t.Fatalf("the preview defined a function")
+	}
+	if outputBase != decimalBase {
+		t.Fatalf("the preview changed a setting")
+	}
+
+	if text, isErr := previewLine("reduce(lrp(3, 1000000), def(a, b){a * b}, 1)"); !isErr || !strings.Contains(text, "longer than") {
+		t.Fatalf("expected the preview to time out but got %q", text)
+	}
+
+	start := time.Now()
+	if text, isErr := previewLine("3^(2^26)"); !isErr || text != "too large to preview" {
+		t.Fatalf("expected 3^(2^26) to be too large to preview but got %q", text)
+	}
+	if d := time.Since(start); d > previewTimeout {
+		t.Fatalf("previewing 3^(2^26) took %v", d)
+	}
+	if CurrentLimits() != DefaultLimits {
+		t.Fatalf("the preview changed the limits of the session")
+	}
+
+	evaluating <- struct{}{}
+	text, _ := previewLine("1 + 1")
+	<-evaluating
+	if text != "" {
+		t.Fatalf("expected no preview while evaluating but got %q", text)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	defer func(w io.Writer) { traceWriter = w }(traceWriter)
+	var buf bytes.Buffer
+	traceWriter = &buf
+	defer undef("tr_sq")
+	defer undef("tr_g")
+	defer untraceFuncs(nil)
+
+	Parse("test", []byte("def tr_sq(a) a*a"))
+	Parse("test", []byte("def tr_g(a) tr_sq(a) + tr_sq(a+1)"))
+	if _, err := Parse("test", []byte("trace tr_g tr_sq abs")); err != nil {
+		t.Fatalf("tracing failed: %v", err)
+	}
+	if _, err := Parse("test", []byte("tr_g(2); abs(-1); untrace tr_sq; tr_g(1)")); err != nil {
+		t.Fatalf("evaluating failed: %v", err)
+	}
+	expected := `-> tr_g(a=2)
+  -> tr_sq(a=2)
+  <- tr_sq(a=2) = 4
+  -> tr_sq(a=3)
+  <- tr_sq(a=3) = 9
+<- tr_g(a=2) = 13
+-> abs(x=-1)
+<- abs(x=-1) = 1.000000
+-> tr_g(a=1)
+<- tr_g(a=1) = 5
+`
+	if buf.String() != expected {
+		t.Fatalf("expected the trace\n%s\nbut got\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	Parse("test", []byte("trace tr_sq; tr_sq(\"a\")"))
+	if out := buf.String(); !strings.Contains(out, "<- tr_sq(a=\"a\"): Error: Unsupported types") {
+		t.Fatalf("expected the error to be traced but got %q", out)
+	}
+
+	// Strings are quoted so that they aren't mistaken for names
+	buf.Reset()
+	defer undef("tr_id")
+	Parse("test", []byte("def tr_id(a) a"))
+	Parse("test", []byte("trace tr_id; tr_id(\"b\")"))
+	if out := buf.String(); out != "-> tr_id(a=\"b\")\n<- tr_id(a=\"b\") = \"b\"\n" {
+		t.Fatalf("unexpected trace of a string %q", out)
+	}
+
+	if _, err := Parse("test", []byte("trace nosuch")); err == nil {
+		t.Fatalf("expected an error tracing a function that doesn't exist")
+	}
+	if _, err := Parse("test", []byte("untrace sqrt")); err == nil {
+		t.Fatalf("expected an error untracing a function that isn't traced")
+	}
+	undef("tr_sq")
+	if traced["tr_sq"] {
+		t.Fatalf("the undefined function is still traced")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	defer func(w io.Writer) { explainWriter = w }(explainWriter)
+	var buf bytes.Buffer
+	explainWriter = &buf
+	defer undef("ex_sq")
+	Parse("test", []byte("def ex_sq(a) a*a"))
+
+	tests := []struct {
+		expr     string
+		expected string
+		err      string
+	}{
+		{"1 + 2.5 * 2", `1 + 2.5 * 2 = 6.000000 (float)
+  1 = 1 (int)
+  2.5 * 2 = 5.000000 (float)
+    2.5 = 2.500000 (float)
+    2 = 2 (int)
+`, ""},
+		{"1-2-3", `1-2-3 = -4 (int)
+  1-2 = -1 (int)
+    1 = 1 (int)
+    2 = 2 (int)
+  3 = 3 (int)
+`, ""},
+		{"ex_sq(1+2) / 0", `ex_sq(1+2) / 0: Error: Division by zero
+  ex_sq(1+2) = 9 (int)
+    1+2 = 3 (int)
+      1 = 1 (int)
+      2 = 2 (int)
+  0 = 0 (int)
+`, "Division by zero"},
+		{"(4)", "(4) = 4 (int)\n", ""},
+		{"-ex_sq(2)", "-ex_sq(2) = -4 (int)\n  ex_sq(2) = 4 (int)\n    2 = 2 (int)\n", ""},
+		{"1 +", "", "no match found"},
+	}
+	for _, tc := range tests {
+		buf.Reset()
+		_, err := Parse("test", []byte("explain "+tc.expr))
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Fatalf("%q: expected error %q but got %v", tc.expr, tc.err, err)
+		}
+		if buf.String() != tc.expected {
+			t.Fatalf("%q: expected\n%s\nbut got\n%s", tc.expr, tc.expected, buf.String())
+		}
+	}
+
+	// explain may also be a variable
+	defer undef("explain")
+	buf.Reset()
+	r, err := Parse("test", []byte("explain = 4; explain - 1"))
+	if l, ok := r.([]interface{}); !ok || err != nil || !teql(l[len(l)-1], big.NewInt(3)) || buf.Len() > 0 {
+		t.Fatalf("expected 3 but got %v, %v, %q", r, err, buf.String())
+	}
+}
+
+func TestTiming(t *testing.T) {
+	defer func(w io.Writer) { timingWriter = w }(timingWriter)
+	var buf bytes.Buffer
+	timingWriter = &buf
+
+	if _, err := Parse("test", []byte("time 2^10")); err != nil || !strings.HasPrefix(buf.String(), "time ") || !strings.Contains(buf.String(), " allocations (") {
+		t.Fatalf("unexpected output of time: %q, %v", buf.String(), err)
+	}
+	buf.Reset()
+	if _, err := Parse("test", []byte("bench 2^10 - 1, 20")); err != nil || !strings.HasPrefix(buf.String(), "20 runs: mean ") || !strings.HasSuffix(buf.String(), " per run\n") {
+		t.Fatalf("unexpected output of bench: %q, %v", buf.String(), err)
+	}
+
+	// The statement words may also be variables
+	defer undef("time")
+	defer undef("bench")
+	buf.Reset()
+	vars := []struct {
+		line     string
+		expected int64
+	}{
+		{"time = 5; time + 1", 6},
+		{"bench = 2; bench * 3", 6},
+		{"bench - 1", 1},
+		{"(time) * bench", 10},
+	}
+	for _, tc := range vars {
+		r, err := Parse("test", []byte(tc.line))
+		if l, ok := r.([]interface{}); ok {
+			r = l[len(l)-1]
+		}
+		if err != nil || !teql(r, big.NewInt(tc.expected)) {
+			t.Fatalf("%q: expected %d but got %v, %v", tc.line, tc.expected, r, err)
+		}
+	}
+	if buf.Len() > 0 {
+		t.Fatalf("a variable was taken as a statement: %q", buf.String())
+	}
+	for _, line := range []string{"time 1/0", "bench 1/0, 5", "time 1 +", "bench 1, x", "bench , 3"} {
+		if _, err := Parse("test", []byte(line)); err == nil {
+			t.Fatalf("%q: expected an error", line)
+		}
+	}
+
+	times := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if p50, p90, p99 := percentile(times, 50), percentile(times, 90), percentile(times, 99); p50 != 5 || p90 != 9 || p99 != 10 {
+		t.Fatalf("unexpected percentiles %v, %v, %v", p50, p90, p99)
+	}
+	if d := roundDuration(1234567 * time.Nanosecond); d != 1230*time.Microsecond {
+		t.Fatalf("expected 1.23ms but got %v", d)
+	}
+	for n, expected := range map[uint64]string{512: "512 B", 1536: "1.5 KB", 3 << 20: "3.0 MB", 5 << 30: "5.0 GB"} {
+		if s := formatBytes(n); s != expected {
+			t.Fatalf("%d: expected %q but got %q", n, expected, s)
+		}
+	}
+}
+
+func TestColorsSetting(t *testing.T) {
+	saved := colorScheme{}
+	for k, v := range colors {
+		saved[k] = v
+	}
+	defer func() {
+		for k, v := range saved {
+			colors[k] = v
+		}
+	}()
+
+	if err := SetSetting("colors", "number=bold+blue,string=none,comment=90"); err != nil {
+		t.Fatalf("setting colors failed: %v", err)
+	}
+	if colors["number"] != "1;34" || colors["string"] != "" || colors["comment"] != "90" || colors["operator"] != saved["operator"] {
+		t.Fatalf("unexpected colors %v", colors)
+	}
+	if s := colors.String(); !strings.Contains(s, "number=1+34") || !strings.Contains(s, "string=none") {
+		t.Fatalf("unexpected string %q", s)
+	}
+	for _, v := range []string{"number", "nosuch=red", "number=purple", "number=1;34"} {
+		if err := SetSetting("colors", v); err == nil {
+			t.Fatalf("%q: expected an error", v)
+		}
+	}
+}
+
+func TestScanLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		code  string
+		depth int
+	}{
+		{"1+2", "1+2", 0},
+		{"1+2 # comment", "1+2 ", 0},
+		{"1/2 // comment", "1/2 ", 0},
+		{`hexdump("#(")`, `hexdump("#(")`, 0},
+		{"[1, (2", "[1, (2", 2},
+		{"3)] # )", "3)] ", -2},
+	}
+
+	for _, tc := range tests {
+		code, depth := scanLine(tc.line)
+		if code != tc.code || depth != tc.depth {
+			t.Fatalf("'%s': expected '%s', %d but got '%s', %d", tc.line, tc.code, tc.depth, code, depth)
+		}
+	}
+}
+
+func TestSuggestNames(t *testing.T) {
+	candidates := []string{"sqrt", "sin", "sinh", "x", "+"}
+
+	tests := []struct {
+		name   string
+		output []string
+	}{
+		{"sqr", []string{"sqrt"}},
+		{"sinn", []string{"sin", "sinh"}},
+		{"X", []string{"x"}},
+		{"y", nil},
+	}
+
+	for _, tc := range tests {
+		if s := suggestNames(tc.name, candidates); !strSliceEql(s, tc.output) {
+			t.Fatalf("suggestions for '%s': expected %v but got %v", tc.name, tc.output, s)
+		}
+	}
+}
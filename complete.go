@@ -0,0 +1,260 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// keywords are the statements, which are completed at the start of a statement.
+var keywords = []string{"set", "def", "help", "apropos", "save", "load", "history", "vars", "funcs", "show", "undef", "reset", "trace", "untrace", "explain", "time", "bench"}
+
+// completionIndex is the set of names of variables and functions that are completed.
+// It is updated as names are defined and deleted rather than rebuilt.
+type completionIndex struct {
+	names map[string]bool
+}
+
+var completions = completionIndex{names: map[string]bool{}}
+
+// add adds name to the index. The numbered results and the script arguments are left
+// out since there are too many of them to be useful.
+func (c *completionIndex) add(name string) {
+	if isIdentifier(name) && !isResultName(name) {
+		c.names[name] = true
+	}
+}
+
+func (c *completionIndex) remove(name string) {
+	// A name may refer to both a variable and a function
+	_, isVar := GlobalVars[name]
+	_, isFunc := Funcs[name]
+	if !isVar && !isFunc {
+		delete(c.names, name)
+	}
+}
+
+// matching returns the names in the index that start with prefix, sorted.
+func (c *completionIndex) matching(prefix string) []string {
+	var l []string
+	for k := range c.names {
+		if strings.HasPrefix(k, prefix) {
+			l = append(l, k)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
+
+// nameSnapshot is a copy of the names of the variables and functions, for the completer
+// and the highlighter. Readline calls them from its own goroutine, even while a line is
+// being evaluated, so they can't read GlobalVars, Funcs or the completion index, which
+// evaluating changes. The REPL takes a new snapshot before reading each line.
+type nameSnapshot struct {
+	vars  map[string]bool
+	funcs map[string]Func
+	// names are the names in the completion index, sorted
+	names []string
+}
+
+var definedNames struct {
+	sync.Mutex
+	snap *nameSnapshot
+}
+
+// takeNameSnapshot copies the names that are defined for the completer and the
+// highlighter. It must be called from the goroutine that evaluates.
+func takeNameSnapshot() {
+	s := &nameSnapshot{
+		vars:  make(map[string]bool, len(GlobalVars)),
+		funcs: make(map[string]Func, len(Funcs)),
+		names: completions.matching(""),
+	}
+	for k := range GlobalVars {
+		s.vars[k] = true
+	}
+	for k, f := range Funcs {
+		s.funcs[k] = f
+	}
+	definedNames.Lock()
+	definedNames.snap = s
+	definedNames.Unlock()
+}
+
+// currentNames returns the latest snapshot of the names that are defined.
+func currentNames() *nameSnapshot {
+	definedNames.Lock()
+	defer definedNames.Unlock()
+	if definedNames.snap == nil {
+		return &nameSnapshot{}
+	}
+	return definedNames.snap
+}
+
+// matching returns the names in the snapshot that start with prefix, sorted.
+func (s *nameSnapshot) matching(prefix string) []string {
+	var l []string
+	for _, k := range s.names {
+		if strings.HasPrefix(k, prefix) {
+			l = append(l, k)
+		}
+	}
+	return l
+}
+
+// completer implements readline.AutoCompleter. It completes the identifier before the
+// cursor anywhere in the line, the names and values of settings after set, and the
+// names after statements that take one. When the cursor is in the parameters of a call
+// it shows the signature of the function.
+type completer struct {
+	// hint is where signatures are shown. If it's nil they aren't.
+	hint io.Writer
+}
+
+var (
+	setNameRe  = regexp.MustCompile(`^\s*set\s+(\w*)$`)
+	setValueRe = regexp.MustCompile(`^\s*set\s+(\w+)\s+(\S*)$`)
+	nameArgRe  = regexp.MustCompile(`^\s*(help|show|undef|trace|untrace)\s+(?:\w+\s+)*(\w*)$`)
+)
+
+func (c *completer) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	// Only the statement that the cursor is in matters
+	before := string(line[:pos])
+	if i := strings.LastIndexByte(before, ';'); i >= 0 {
+		before = before[i+1:]
+	}
+	after := identifierAt(line[pos:])
+	names := currentNames()
+
+	if m := setNameRe.FindStringSubmatch(before); m != nil {
+		return candidates(m[1], after, settingNames(), " ")
+	}
+	if m := setValueRe.FindStringSubmatch(before); m != nil {
+		if s, ok := Settings[m[1]]; ok {
+			if v, ok := s.Setting.(settingValuer); ok {
+				return candidates(m[2], after, v.Values(), "")
+			}
+		}
+		return nil, 0
+	}
+	if m := nameArgRe.FindStringSubmatch(before); m != nil {
+		l := names.matching("")
+		switch m[1] {
+		case "help":
+			l = append(l, categoryNames()...)
+		case "show":
+			l = append(l, "settings")
+		}
+		return candidates(m[2], after, l, "")
+	}
+
+	prefix := identifierBefore(before)
+	if prefix == "" {
+		if name, ok := enclosingCall(before, names.funcs); ok {
+			c.showSignature(name, names.funcs[name])
+			return nil, 0
+		}
+	}
+
+	var l [][]rune
+	if strings.TrimSpace(before) == prefix {
+		l, _ = candidates(prefix, after, keywords, " ")
+	}
+	for _, k := range names.matching(prefix) {
+		suffix := ""
+		if _, ok := names.funcs[k]; ok && after == "" {
+			suffix = "("
+		}
+		m, _ := candidates(prefix, after, []string{k}, suffix)
+		l = append(l, m...)
+	}
+	return l, len([]rune(prefix))
+}
+
+// candidates returns the completions of prefix from names: the rest of each name that
+// starts with prefix, followed by suffix. If the cursor is within an identifier, after
+// is the part of it after the cursor, and only names that end with it are completed.
+func candidates(prefix, after string, names []string, suffix string) ([][]rune, int) {
+	var l [][]rune
+	for _, n := range names {
+		if !strings.HasPrefix(n, prefix) || !strings.HasSuffix(n[len(prefix):], after) {
+			continue
+		}
+		rest := n[len(prefix) : len(n)-len(after)]
+		l = append(l, []rune(rest+suffix))
+	}
+	return l, len([]rune(prefix))
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// identifierBefore returns the identifier characters at the end of s.
+func identifierBefore(s string) string {
+	r := []rune(s)
+	i := len(r)
+	for i > 0 && isIdentRune(r[i-1]) {
+		i--
+	}
+	return string(r[i:])
+}
+
+// identifierAt returns the identifier characters at the start of r.
+func identifierAt(r []rune) string {
+	i := 0
+	for i < len(r) && isIdentRune(r[i]) {
+		i++
+	}
+	return string(r[:i])
+}
+
+// enclosingCall returns the name of the function in funcs whose parameters end s, if s
+// ends within the parentheses of a call.
+func enclosingCall(s string, funcs map[string]Func) (string, bool) {
+	depth := 0
+	r := []rune(s)
+	for i := len(r) - 1; i >= 0; i-- {
+		switch r[i] {
+		case ')', ']', '}':
+			depth++
+		case '[', '{':
+			depth--
+		case '(':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			name := identifierBefore(string(r[:i]))
+			_, ok := funcs[name]
+			return name, ok
+		}
+		if depth < 0 {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (c *completer) showSignature(name string, f Func) {
+	if c.hint == nil {
+		return
+	}
+	s := signature(name, f, true)
+	if h := helpText(f); h != "" {
+		s += ": " + h
+	}
+	io.WriteString(c.hint, s+"\n")
+}
+
+func settingNames() []string {
+	names := make([]string, 0, len(Settings))
+	for k := range Settings {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,155 @@
+package main
+
+import "strings"
+
+// builtinDoc documents a builtin function beyond its help: the names of its parameters
+// and an example of calling it, with the result that the example gives. The result is
+// empty for functions such as roll whose results vary.
+type builtinDoc struct {
+	name    string
+	params  string
+	example string
+	result  string
+}
+
+// builtinCategories groups the builtin functions by what they work on. Help lists the
+// categories, and the functions in them, in this order.
+var builtinCategories = []struct {
+	name string
+	docs []builtinDoc
+}{
+	{"operators", []builtinDoc{
+		{"+", "a, b", "2 + 3", "5"},
+		{"-", "a, b", "7 - 2", "5"},
+		{"*", "a, b", "6 * 7", "42"},
+		{"/", "a, b", "7 / 2", "3"},
+		{"^", "a, b", "2 ^ 10", "1024"},
+		{"&", "a, b", "0xf0 & 0x3c", "0x30"},
+		{"|", "a, b", "0xf0 | 0x0f", "0xff"},
+		{"~", "a", "~5", "-6"},
+		{"neg", "a", "neg(5)", "-5"},
+		{"lsh", "a, n", "lsh(1, 8)", "256"},
+		{"rsh", "a, n", "rsh(256, 4)", "16"},
+	}},
+	{"math", []builtinDoc{
+		{"abs", "x", "abs(-2.5)", "2.500000"},
+		{"acos", "x", "acos(1.0)", "0.000000"},
+		{"acosh", "x", "acosh(1.0)", "0.000000"},
+		{"asin", "x", "asin(1.0)", "1.570796"},
+		{"asinh", "x", "asinh(0.0)", "0.000000"},
+		{"atan", "x", "atan(1.0)", "0.785398"},
+		{"atanh", "x", "atanh(0.5)", "0.549306"},
+		{"cbrt", "x", "cbrt(27.0)", "3.000000"},
+		{"ceil", "x", "ceil(2.1)", "3.000000"},
+		{"cos", "x", "cos(0.0)", "1.000000"},
+		{"cosh", "x", "cosh(0.0)", "1.000000"},
+		{"erf", "x", "erf(1.0)", "0.842701"},
+		{"erfc", "x", "erfc(1.0)", "0.157299"},
+		{"exp", "x", "exp(1.0)", "2.718282"},
+		{"exp2", "x", "exp2(10.0)", "1024.000000"},
+		{"exp10", "x", "exp10(3.0)", "1000.000000"},
+		{"floor", "x", "floor(2.9)", "2.000000"},
+		{"gamma", "x", "gamma(5.0)", "24.000000"},
+		{"hypot", "x, y", "hypot(3.0, 4.0)", "5.000000"},
+		{"j0", "x", "j0(1.0)", "0.765198"},
+		{"j1", "x", "j1(1.0)", "0.440051"},
+		{"log", "x", "log(10.0)", "2.302585"},
+		{"log10", "x", "log10(1000.0)", "3.000000"},
+		{"log2", "x", "log2(1024.0)", "10.000000"},
+		{"sin", "x", "sin(0.0)", "0.000000"},
+		{"sinh", "x", "sinh(1.0)", "1.175201"},
+		{"sqrt", "x", "sqrt(2.0)", "1.414214"},
+		{"tan", "x", "tan(1.0)", "1.557408"},
+		{"tanh", "x", "tanh(1.0)", "0.761594"},
+		{"y0", "x", "y0(1.0)", "0.088257"},
+		{"y1", "x", "y1(1.0)", "-0.781213"},
+		{"binom", "n, k", "binom(5, 2)", "10"},
+		{"choose", "n, k", "choose(5, 2)", "10"},
+		{"powmod", "a, e, m", "powmod(4, 13, 497)", "445"},
+		{"invmod", "a, m", "invmod(3, 11)", "4"},
+	}},
+	{"bits", []builtinDoc{
+		{"bit", "n, i", "bit(5, 2)", "1"},
+		{"bytes", "n", "bytes(0x1234)", "[18, 52]"},
+		{"unbytes", "l", "unbytes([0x34, 0x12])", "0x3412"},
+	}},
+	{"lists", []builtinDoc{
+		{"llen", "l", "llen([1, 2, 3])", "3"},
+		{"li", "l, i", "li([5, 6, 7], 1)", "6"},
+		{"lrev", "l", "lrev([1, 2, 3])", "[3, 2, 1]"},
+		{"lrp", "e, n", "lrp(0, 3)", "[0, 0, 0]"},
+		{"map", "l, f", "map([1, 2, 3], def(x){x*x})", "[1, 4, 9]"},
+		{"reduce", "l, f, init", "reduce([1, 2, 3], +, 0)", "6"},
+		{"filter", "l, f", "filter([1, 2, 3, 4], def(x){x > 2})", "[3, 4]"},
+		{"hexdump", "l", "hexdump([0x41, 0x42])", ""},
+		{"unhexdump", "s", `unhexdump("00000000  41 42")`, "[65, 66]"},
+	}},
+	{"network", []builtinDoc{
+		{"ipv4", "n", "ipv4(0x0a000001)", "10.0.0.1"},
+		{"ipv6", "n", "ipv6(1)", "::1"},
+		{"ipnum", "addr", "ipnum(10.0.0.1)", "167772161"},
+		{"cidr", "addr, len", "cidr(10.1.2.3, 8)", "10.1.2.3/8"},
+		{"prefixlen", "net", "prefixlen(10.0.0.0/8)", "8"},
+		{"network", "net", "network(10.1.2.3/8)", "10.0.0.0/8"},
+		{"broadcast", "net", "broadcast(10.0.0.0/8)", "10.255.255.255/8"},
+		{"netmask", "net", "netmask(10.0.0.0/8)", "255.0.0.0"},
+		{"hostcount", "net", "hostcount(192.168.1.0/24)", "254"},
+		{"contains", "net, addr", "contains(10.0.0.0/8, 10.1.2.3)", "1"},
+		{"subnets", "net, len", "subnets(10.0.0.0/23, 24)", "[10.0.0.0/24, 10.0.1.0/24]"},
+		{"mac", "s", `mac("00:11:22:33:44:55")`, "0x1122334455"},
+		{"macstr", "n", "macstr(0x001122334455)", "00:11:22:33:44:55"},
+		{"mac_to_eui64", "mac", "mac_to_eui64(0x001122334455)", "0x21122fffe334455"},
+		{"eui64_to_mac", "id", "eui64_to_mac(0x021122fffe334455)", "0x1122334455"},
+		{"ipv6_eui64", "net, mac", "ipv6_eui64(fe80::/64, 0x001122334455)", "fe80::211:22ff:fe33:4455"},
+	}},
+	{"hashes", []builtinDoc{
+		{"crc32", "l", "crc32([0x61, 0x62, 0x63])", "0x352441c2"},
+		{"crc32c", "l", "crc32c([0x61, 0x62, 0x63])", "0x364b3fb7"},
+		{"crc16", "l, poly", "crc16([0x61, 0x62, 0x63], 0x1021)", "0x9dd6"},
+		{"crc8", "l, poly", "crc8([0x61, 0x62, 0x63], 0x07)", "0x5f"},
+		{"crc", "l, width, poly, init, reflect, xorout", "crc([0x61, 0x62, 0x63], 16, 0x8005, 0, 1, 0)", "0x9738"},
+		{"adler32", "l", "adler32([0x61, 0x62, 0x63])", "0x24d0127"},
+		{"fnv1a32", "l", "fnv1a32([0x61, 0x62, 0x63])", "0x1a47e90b"},
+		{"fnv1a64", "l", "fnv1a64([0x61, 0x62, 0x63])", "0xe71fa2190541574b"},
+		{"md5", "l", "md5([0x61, 0x62, 0x63])", "0x900150983cd24fb0d6963f7d28e17f72"},
+		{"sha1", "l", "sha1([0x61, 0x62, 0x63])", "0xa9993e364706816aba3e25717850c26c9cd0d89d"},
+		{"sha256", "l", "sha256([0x61, 0x62, 0x63])", "0xba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"sha512", "l", "sha512([0x61, 0x62, 0x63])", "0xddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"},
+		{"luhn", "n", "luhn(7992739871)", "3"},
+		{"isbn10", "n", "isbn10(30640615)", "2"},
+		{"isbn13", "n", "isbn13(978030640615)", "7"},
+	}},
+	{"polynomials", []builtinDoc{
+		{"clmul", "a, b", "clmul(0b11, 0b11)", "0x5"},
+		{"pmod", "a, m", "pmod(0b1011, 0b11)", "0x1"},
+		{"pdiv", "a, m", "pdiv(0b1011, 0b11)", "0x6"},
+		{"pgcd", "a, b", "pgcd(0b110, 0b1010)", "0x6"},
+		{"gfmul", "a, b, poly", "gfmul(0x53, 0xca, 0x11b)", "0x1"},
+		{"gfinv", "a, poly", "gfinv(0x53, 0x11b)", "0xca"},
+	}},
+	{"general", []builtinDoc{
+		{"if", "cond, then, ...", "if(0, 1, 2)", "2"},
+		{"roll", "n, sides", "roll(3, 6)", ""},
+		{"now", "", "now()", ""},
+		{"env", "name", `env("HOME")`, ""},
+		{"out", "n", "out(-1)", ""},
+	}},
+}
+
+// documentBuiltins adds the parameter names, category and example of each builtin
+// function in builtinCategories to the function.
+func documentBuiltins() {
+	for _, c := range builtinCategories {
+		for _, d := range c.docs {
+			f, ok := Funcs[d.name].(*BuiltinFunc)
+			if !ok {
+				continue
+			}
+			f.category = c.name
+			f.example, f.result = d.example, d.result
+			if d.params != "" {
+				f.params = strings.Split(d.params, ", ")
+			}
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type ErrDivisionByZero string
+
+func NewErrDivisionByZero() ErrDivisionByZero {
+	return ErrDivisionByZero("Division by zero")
+}
+
+func (e ErrDivisionByZero) Error() string {
+	return string(e)
+}
+
+// ErrNaN is returned when the result of a calculation is not a number,
+// for example the square root of a negative number.
+type ErrNaN string
+
+func NewErrNaN(reason string) ErrNaN {
+	return ErrNaN(fmt.Sprintf("Result is not a number: %s", reason))
+}
+
+func (e ErrNaN) Error() string {
+	return string(e)
+}
+
+// ErrInfinity is returned when the result of a calculation is infinite and
+// the ieee setting is off.
+type ErrInfinity string
+
+func NewErrInfinity(f *big.Float) ErrInfinity {
+	return ErrInfinity(fmt.Sprintf("Result is %v", f))
+}
+
+func (e ErrInfinity) Error() string {
+	return string(e)
+}
+
+// NaN is the value of a calculation that is not a number when the ieee setting is on.
+type NaN struct{}
+
+func (n NaN) String() string {
+	return "NaN"
+}
+
+func isNaN(v interface{}) bool {
+	_, ok := v.(NaN)
+	return ok
+}
+
+// ieee controls whether infinities and NaN are values (as in IEEE 754 floating point)
+// or errors.
+var ieee boolSetting
+
+// ieeeDivByZero returns true if dividing the value a by zero should produce an infinity
+// (or NaN) rather than an error.
+func ieeeDivByZero(a interface{}) bool {
+	_, isFloat := a.(*big.Float)
+	return bool(ieee) && isFloat
+}
+
+// checkFloat returns an error if f is infinite and the ieee setting is off.
+func checkFloat(f *big.Float) (*big.Float, error) {
+	if f.IsInf() && !bool(ieee) {
+		return nil, NewErrInfinity(f)
+	}
+	return f, nil
+}
+
+// recoverNaN is deferred by functions that operate on big.Floats to convert the panic
+// raised for operations like Inf-Inf or 0/0.0 into a NaN value or error, depending on
+// the ieee setting. r and err point to the function's results.
+func recoverNaN(r *interface{}, err *error) {
+	e := recover()
+	if e == nil {
+		return
+	}
+
+	nan, ok := e.(big.ErrNaN)
+	if !ok {
+		panic(e)
+	}
+
+	if ieee {
+		*r, *err = NaN{}, nil
+	} else {
+		*r, *err = nil, NewErrNaN(nan.Error())
+	}
+}
+
+// errNoValue is returned when an operand has no value because evaluating it failed.
+// The error for the failure has already been reported, so errNoValue is not.
+var errNoValue = errors.New("operand has no value")
+
+// noValue returns true if v is nil, or is a nil pointer or slice. Functions and operators
+// return these along with an error when they fail.
+func noValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// typeName returns the name of the type of the value v as it would be described to the user.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case *big.Int:
+		return "int"
+	case *big.Float:
+		return "float"
+	case BigIntList:
+		return "int list"
+	case BigFloatList:
+		return "float list"
+	case IPAddr:
+		return "ip address"
+	case IPAddrList:
+		return "ip address list"
+	case string:
+		return "string"
+	case NaN:
+		return "NaN"
+	case Func:
+		return "function"
+	case nil:
+		return "nothing"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// posError is an error that occurred at a more precise position than the start
+// of the rule that was being evaluated, such as at an operator.
+type posError struct {
+	pos position
+	err error
+}
+
+func (e *posError) Error() string {
+	return e.err.Error()
+}
+
+func (e *posError) Unwrap() error {
+	return e.err
+}
+
+// suggester is implemented by errors for unknown names that can suggest similar
+// names that are defined.
+type suggester interface {
+	Suggestions() []string
+}
+
+// knownNames returns the names of all variables and functions
+func knownNames() []string {
+	names := make([]string, 0, len(GlobalVars)+len(LocalVars)+len(Funcs))
+	for k := range LocalVars {
+		names = append(names, k)
+	}
+	for k := range GlobalVars {
+		names = append(names, k)
+	}
+	for k := range Funcs {
+		names = append(names, k)
+	}
+	return names
+}
+
+// suggestNames returns up to three of the candidates that are most similar to name.
+func suggestNames(name string, candidates []string) []string {
+	type match struct {
+		name string
+		dist int
+	}
+
+	// Allow more differences for longer names. Single character names only match
+	// if they differ in case.
+	max := (len(name) + 1) / 3
+	lname := strings.ToLower(name)
+
+	var matches []match
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		if c == name || seen[c] || !isIdentifier(c) {
+			continue
+		}
+		seen[c] = true
+		if d := editDistance(lname, strings.ToLower(c)); d <= max {
+			matches = append(matches, match{c, d})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].dist != matches[j].dist {
+			return matches[i].dist < matches[j].dist
+		}
+		return matches[i].name < matches[j].name
+	})
+
+	var r []string
+	for i := 0; i < len(matches) && i < 3; i++ {
+		r = append(r, matches[i].name)
+	}
+	return r
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9' {
+			continue
+		}
+		return false
+	}
+	return len(s) > 0
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+func minInt(a int, rest ...int) int {
+	for _, v := range rest {
+		if v < a {
+			a = v
+		}
+	}
+	return a
+}
+
+// errorPosition returns the position in the input of an error returned by Parse,
+// and the underlying error without the position information.
+func errorPosition(err error) (pos position, inner error, ok bool) {
+	inner = err
+	if pe, isParserErr := err.(*parserError); isParserErr {
+		pos, inner, ok = pe.pos, pe.Inner, true
+	}
+	if pe, isPosErr := inner.(*posError); isPosErr {
+		pos, inner, ok = pe.pos, pe.err, true
+	}
+	return
+}
+
+// rootError returns the error that caused err, without the position information added by
+// the parser. Errors that occurred while calling a user-defined function are nested inside
+// the error for the call.
+func rootError(err error) error {
+	err = stripPosition(err)
+	if ce, ok := err.(*CallError); ok {
+		return ce.Err
+	}
+	return err
+}
+
+// errorMessage returns the message for an error without position information, with
+// suggestions for unknown names appended.
+func errorMessage(err error) string {
+	err = rootError(err)
+	msg := err.Error()
+	if s, ok := err.(suggester); ok {
+		if names := s.Suggestions(); len(names) > 0 {
+			msg += fmt.Sprintf(". Did you mean %s?", strings.Join(names, ", "))
+		}
+	}
+	return msg
+}
+
+// reportError prints the error err that resulted from parsing src to w. Each error
+// is printed with it's line and column, followed by the offending line of src with a
+// caret under the position of the error.
+func reportError(w io.Writer, src string, err error) {
+	lines := strings.Split(src, "\n")
+	reportErrorAt(w, err, func(pos position) (loc sourceLocation, ok bool) {
+		if pos.line < 1 || pos.line > len(lines) {
+			return
+		}
+		return sourceLocation{line: pos.line, col: pos.col, text: lines[pos.line-1]}, true
+	})
+}
+
+// sourceLocation is a location in the source of the parsed text.
+type sourceLocation struct {
+	name      string // name of the source, such as a file name
+	line, col int
+	text      string // the text of the line
+}
+
+func (l sourceLocation) String() string {
+	if l.name == "" {
+		return fmt.Sprintf("%d:%d", l.line, l.col)
+	}
+	return fmt.Sprintf("%s:%d:%d", l.name, l.line, l.col)
+}
+
+// locator maps a position in the parsed text to its location in the source.
+type locator func(pos position) (loc sourceLocation, ok bool)
+
+// reportErrorAt prints the error err to w, using locate to find the source of each error.
+func reportErrorAt(w io.Writer, err error, locate locator) {
+	errs := []error{err}
+	if el, ok := err.(errList); ok {
+		errs = el
+	}
+
+	for _, e := range errs {
+		pos, inner, ok := errorPosition(e)
+		if inner == errNoValue {
+			continue
+		}
+		var loc sourceLocation
+		if ok {
+			loc, ok = locate(pos)
+		}
+		if !ok {
+			fmt.Fprintf(w, "Error: %s\n", errorMessage(inner))
+			printTrace(w, callTrace(inner))
+			continue
+		}
+
+		fmt.Fprintf(w, "Error: %s: %s\n", loc, errorMessage(inner))
+		fmt.Fprintf(w, "  %s\n  %s^\n", loc.text, caretPadding(loc.text, loc.col))
+		printTrace(w, callTrace(inner))
+	}
+}
+
+// caretPadding returns whitespace that positions a caret under column col of line,
+// preserving tabs so that the caret lines up.
+func caretPadding(line string, col int) string {
+	var buf strings.Builder
+	i := 1
+	for _, r := range line {
+		if i >= col {
+			break
+		}
+		if r == '\t' {
+			buf.WriteRune('\t')
+		} else {
+			buf.WriteRune(' ')
+		}
+		i++
+	}
+	for ; i < col; i++ {
+		buf.WriteRune(' ')
+	}
+	return buf.String()
+}
@@ -58,6 +58,37 @@ func upcast(a, b interface{}) (an, bn interface{}, isInt bool) {
 // portions up to that point may have been calculated using integer arithmetic; this
 // may lead to odd behavior for division.
 func evalBinaryOp(op string, a, b interface{}) (r interface{}, err error) {
+	defer recoverNaN(&r, &err)
+	defer checkResultLimits(&r, &err)
+
+	if noValue(a) || noValue(b) {
+		return nil, errNoValue
+	}
+
+	if err = step(); err != nil {
+		return
+	}
+
+	if isNaN(a) || isNaN(b) {
+		return evalNaNBinaryOp(op)
+	}
+
+	if isIPValue(a) || isIPValue(b) {
+		return evalIPBinaryOp(op, a, b)
+	}
+
+	if modulus.active() {
+		if isModularOp(op) {
+			return evalModularOp(op, a, b)
+		}
+		// The other operators, such as comparisons, operate on the reduced values
+		a, b = modValue(a), modValue(b)
+		defer func() { r = modValue(r) }()
+	}
+
+	if err = checkOpLimits(op, a, b); err != nil {
+		return
+	}
 
 	switch op {
 	case "+":
@@ -93,13 +124,46 @@ func evalBinaryOp(op string, a, b interface{}) (r interface{}, err error) {
 	return nil, fmt.Errorf("Unsupported operation %v", op)
 }
 
+// evalNaNBinaryOp evaluates op when one of the operands is NaN. Like in IEEE 754
+// arithmetic the result is NaN, and NaN compares unequal to everything.
+func evalNaNBinaryOp(op string) (r interface{}, err error) {
+	switch op {
+	case "<", ">", "=", "<=", ">=":
+		return big.NewInt(0), nil
+	case "+", "-", "*", "/", "^":
+		return NaN{}, nil
+	}
+	return nil, fmt.Errorf("the '%s' operation is not defined for NaN", op)
+}
+
+// binaryOpFunc returns a function that evaluates the binary operator op the same way as it
+// is evaluated in an expression. It is used to register the operators as functions.
+func binaryOpFunc(op string) func(a, b interface{}) (interface{}, error) {
+	return func(a, b interface{}) (interface{}, error) {
+		return evalBinaryOp(op, a, b)
+	}
+}
+
 func evalUnaryOp(op rune, a interface{}) (r interface{}, err error) {
+	if noValue(a) {
+		return nil, errNoValue
+	}
+	if err = step(); err != nil {
+		return
+	}
+	if isNaN(a) {
+		return a, nil
+	}
 
 	switch op {
 	case '-':
+		if modulus.active() {
+			return modNeg(a)
+		}
 		return neg(a)
 	case '~':
-		return not(a)
+		r, err = not(a)
+		return modValue(r), err
 	}
 
 	return nil, fmt.Errorf("Unsupported operation %v", op)
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// explainNode is a sub-expression of an explained expression: the span of the source
+// that it was parsed from, its value and the sub-expressions it was evaluated from. The
+// value is formatted when it's recorded since the operators may reuse their operands.
+type explainNode struct {
+	start, end int
+	value, typ string
+	err        error
+	children   []*explainNode
+}
+
+// explainTree builds the tree of an explained expression as it is evaluated. The actions
+// of the grammar record each sub-expression after those it contains, so the stack holds
+// the sub-expressions that haven't yet been adopted by an enclosing one.
+type explainTree struct {
+	stack []*explainNode
+}
+
+// explainer is the tree being built while an explain statement is evaluated, or nil.
+var explainer *explainTree
+
+// explainWriter is where explain statements print the tree.
+var explainWriter io.Writer = textOut
+
+// explained records the value of the rule matched by c, if an expression is being
+// explained, and returns v and err unchanged.
+func explained(c *current, v interface{}, err error) (interface{}, error) {
+	explainSpan(c.pos.offset, c.pos.offset+len(c.text), v, err)
+	return v, err
+}
+
+// explainSpan records the value of the sub-expression that spans [start, end) of the
+// source. The sub-expressions recorded within that span become its children.
+func explainSpan(start, end int, v interface{}, err error) {
+	if explainer == nil {
+		return
+	}
+	t := explainer
+	n := &explainNode{start: start, end: end, value: formatValue(v), typ: typeName(v), err: err}
+
+	// The operands after the span are already recorded when the operations of a binary
+	// expression are, so the children aren't necessarily at the top of the stack
+	j := len(t.stack)
+	for j > 0 && t.stack[j-1].start >= end {
+		j--
+	}
+	i := j
+	for i > 0 && t.stack[i-1].start >= start && t.stack[i-1].end <= end {
+		i--
+	}
+	for _, child := range t.stack[i:j] {
+		// The parser may evaluate a sub-expression and then backtrack and evaluate it
+		// again. Only the last evaluation of a span is kept.
+		if last := len(n.children) - 1; last >= 0 && child.start < n.children[last].end {
+			n.children = n.children[:last]
+		}
+		n.children = append(n.children, child)
+	}
+
+	// An error in a sub-expression doesn't always stop the evaluation of the
+	// expression, but it makes its value meaningless
+	for _, child := range n.children {
+		if n.err == nil && child.err != nil {
+			n.err = child.err
+		}
+	}
+
+	// A node that only repeats its one child, such as a number, says nothing more
+	if len(n.children) == 1 && sameExplanation(n, n.children[0]) {
+		n.children = n.children[0].children
+	}
+	rest := append([]*explainNode{n}, t.stack[j:]...)
+	t.stack = append(t.stack[:i], rest...)
+}
+
+func sameExplanation(a, b *explainNode) bool {
+	if (a.err == nil) != (b.err == nil) {
+		return false
+	}
+	return a.typ == b.typ && a.value == b.value
+}
+
+// suspendExplain stops recording sub-expressions, for the parses that aren't part of
+// the explained expression such as the bodies of functions. It returns a function that
+// resumes recording.
+func suspendExplain() func() {
+	t := explainer
+	explainer = nil
+	return func() { explainer = t }
+}
+
+// explainEntrypoint is set to the option that parses the Explain rule when the package
+// is initialized. Making it in explain would make an initialization cycle, since the
+// explain statement calls explain.
+var explainEntrypoint Option
+
+func init() {
+	explainEntrypoint = Entrypoint("Explain")
+}
+
+// explain evaluates the expression src and prints the tree of its sub-expressions with
+// the value and type of each.
+func explain(src string) error {
+	explainer = &explainTree{}
+	defer func() { explainer = nil }()
+
+	// The Explain rule's value is whether it matched, so that a syntax error can be
+	// told apart from an error in evaluating the expression
+	matched, err := funcParse("explain", []byte(src), explainEntrypoint)
+	if matched == true {
+		for _, n := range explainer.stack {
+			printExplainNode(explainWriter, src, n, 0)
+		}
+	}
+	if err != nil {
+		// The positions of errors are within src rather than the statement, so only
+		// the message is returned. The tree shows where an error occurred.
+		return errors.New(errorMessage(firstError(err)))
+	}
+	return nil
+}
+
+func printExplainNode(w io.Writer, src string, n *explainNode, depth int) {
+	text := strings.TrimSpace(src[n.start:n.end])
+	indent := strings.Repeat("  ", depth)
+	if n.err != nil {
+		fmt.Fprintf(w, "%s%s: Error: %s\n", indent, text, errorMessage(firstError(n.err)))
+	} else {
+		fmt.Fprintf(w, "%s%s = %s (%s)\n", indent, text, n.value, n.typ)
+	}
+	for _, c := range n.children {
+		printExplainNode(w, src, c, depth+1)
+	}
+}
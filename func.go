@@ -16,9 +16,42 @@ type BuiltinFunc struct {
 	help string
 	fn   reflect.Value
 	typ  reflect.Type
+
+	// Documentation set by documentBuiltins
+	category string
+	params   []string
+	example  string
+	result   string
 }
 
 func (f BuiltinFunc) Call(parms []interface{}) (result interface{}, err error) {
+	if traced[f.name] {
+		// Deferred first so that the result is written once errors are recovered
+		defer traceCall(Frame{Name: f.name, Params: funcParams(&f), Args: append([]interface{}(nil), parms...)})(&result, &err)
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if nan, ok := e.(big.ErrNaN); ok {
+				result, err = nil, NewErrNaN(nan.Error())
+				if ieee {
+					result, err = NaN{}, nil
+				}
+				return
+			}
+			result, err = nil, fmt.Errorf("%s failed: %v", f.name, e)
+		}
+	}()
+
+	for _, p := range parms {
+		if noValue(p) {
+			return nil, errNoValue
+		}
+	}
+
+	if err = step(); err != nil {
+		return
+	}
+	defer checkResultLimits(&result, &err)
 
 	// Validate arity
 	if !f.typ.IsVariadic() {
@@ -41,7 +74,7 @@ func (f BuiltinFunc) Call(parms []interface{}) (result interface{}, err error) {
 				}
 
 				if !p.AssignableTo(t) {
-					err = fmt.Errorf("Parameter %d is invalid: expected %s but got %s", i+1, t, p)
+					err = ErrParamType{i + 1, t, p}
 					return
 				}
 			}
@@ -58,6 +91,11 @@ func (f BuiltinFunc) Call(parms []interface{}) (result interface{}, err error) {
 	result = resultVals[0].Interface()
 	if !resultVals[1].IsNil() {
 		err = resultVals[1].Interface().(error)
+		// Errors in functions passed to builtins such as map include the call
+		// of the builtin in their trace.
+		if ce, ok := err.(*CallError); ok {
+			err = &CallError{Err: ce.Err, Trace: append(ce.Trace, Frame{Name: f.name, Args: parms})}
+		}
 	}
 
 	return result, err
@@ -82,10 +120,38 @@ type DefinedFunc struct {
 	paramNames []string
 	body       []byte
 	bound      map[string]interface{}
+	seq        int
 }
 
 func (f DefinedFunc) Call(parms []interface{}) (result interface{}, err error) {
-	defer ClearLocals()
+	if traced[f.name] {
+		defer traceCall(Frame{Name: f.name, Params: f.paramNames, Args: parms})(&result, &err)
+	}
+	// The body is explained by the call's value, not part by part
+	defer suspendExplain()()
+
+	for _, p := range parms {
+		if noValue(p) {
+			return nil, errNoValue
+		}
+	}
+
+	if err = step(); err != nil {
+		return
+	}
+	if err = checkDepth(len(callStack) + 1); err != nil {
+		return
+	}
+
+	// Each call has its own local variables, so that calling a function from
+	// another doesn't clobber the caller's parameters.
+	callerLocals := LocalVars
+	LocalVars = map[string]interface{}{}
+	pushFrame(&f, parms)
+	defer func() {
+		popFrame()
+		LocalVars = callerLocals
+	}()
 
 	if f.bound != nil {
 		for i, bvar := range f.bound {
@@ -100,7 +166,11 @@ func (f DefinedFunc) Call(parms []interface{}) (result interface{}, err error) {
 		LocalVars[f.paramNames[i]] = parm
 	}
 
-	return Parse("function call", f.body)
+	result, err = Parse("function call", f.body)
+	if err != nil {
+		err = withFrame(err, Frame{Name: f.name, Params: f.paramNames, Args: parms, Body: string(f.body)})
+	}
+	return
 }
 
 func (f DefinedFunc) Help() string {
@@ -125,24 +195,27 @@ func RegisterBuiltin(name string, fn interface{}, help string) Func {
 	}
 
 	Funcs[f.name] = f
-
-	updateAutocomplete()
+	completions.add(f.name)
 
 	return f
 }
 
+// definedCount numbers the user-defined functions in the order they were defined.
+var definedCount int
+
 func RegisterDefined(name string, paramNames []string, body []byte, help string) Func {
 
+	definedCount++
 	f := &DefinedFunc{
 		name:       name,
 		help:       help,
 		paramNames: paramNames,
 		body:       body,
+		seq:        definedCount,
 	}
 
 	Funcs[f.name] = f
-
-	updateAutocomplete()
+	completions.add(f.name)
 
 	return f
 }
@@ -162,7 +235,30 @@ func Call(name string, parms []interface{}) (result interface{}, err error) {
 		}
 	}
 
-	return nil, fmt.Errorf("No such function %s", name)
+	return nil, ErrNoSuchFunc{name}
+}
+
+// ErrParamType is the error of calling a builtin function with a parameter of the
+// wrong type.
+type ErrParamType struct {
+	N             int
+	Expected, Got reflect.Type
+}
+
+func (e ErrParamType) Error() string {
+	return fmt.Sprintf("Parameter %d is invalid: expected %s but got %s", e.N, e.Expected, e.Got)
+}
+
+type ErrNoSuchFunc struct {
+	Name string
+}
+
+func (e ErrNoSuchFunc) Error() string {
+	return fmt.Sprintf("No such function %s", e.Name)
+}
+
+func (e ErrNoSuchFunc) Suggestions() []string {
+	return suggestNames(e.Name, knownNames())
 }
 
 var funcParse func(filename string, b []byte, opts ...Option) (interface{}, error)
@@ -184,6 +280,7 @@ func validateFuncDef(parms []interface{}, body []byte) error {
 	if funcParse == nil {
 		return fmt.Errorf("funcParse was not set")
 	}
+	defer suspendExplain()()
 	_, err := funcParse("function def", body)
 
 	if err == nil {
@@ -201,7 +298,11 @@ func validateFuncDef(parms []interface{}, body []byte) error {
 	nl := make(errList, 0, m)
 	for _, e := range el {
 		inner := e.(*parserError).Inner
-		if _, ok := inner.(ErrUnboundVar); !ok {
+		// The parameters are unbound, and evaluate to a placeholder int. So a
+		// function that needs another type, such as an address, fails as well.
+		switch rootError(inner).(type) {
+		case ErrUnboundVar, ErrParamType:
+		default:
 			nl = append(nl, inner)
 		}
 	}
@@ -3,7 +3,7 @@ module github.com/jeffwilliams/calc
 go 1.17
 
 require (
-	github.com/chzyer/readline v0.0.0-20160726135117-62c6fe619375
+	github.com/chzyer/readline v1.5.1
 	github.com/spf13/pflag v1.0.0
 )
 
@@ -11,7 +11,7 @@ require (
 	github.com/cheekybits/genny v1.0.0 // indirect
 	github.com/chzyer/test v1.0.0 // indirect
 	golang.org/x/mod v0.6.0-dev.0.20220106191415-9b9b3d81d5e3 // indirect
-	golang.org/x/sys v0.0.0-20211019181941-9d821ace8654 // indirect
+	golang.org/x/sys v0.0.0-20220310020820-b874c991c1a5 // indirect
 	golang.org/x/tools v0.1.10 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )
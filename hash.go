@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"hash/fnv"
+	"math/big"
+)
+
+// hashList returns a function that computes the hash h over a list of bytes and returns
+// the sum as an integer.
+func hashList(h func() hash.Hash) func(l BigIntList) (*big.Int, error) {
+	return func(l BigIntList) (*big.Int, error) {
+		b, err := listToBytes(l)
+		if err != nil {
+			return nil, err
+		}
+		hh := h()
+		hh.Write(b)
+		return big.NewInt(0).SetBytes(hh.Sum(nil)), nil
+	}
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+func crc32IEEE(l BigIntList) (*big.Int, error) {
+	b, err := listToBytes(l)
+	if err != nil {
+		return nil, err
+	}
+	return big.NewInt(int64(crc32.ChecksumIEEE(b))), nil
+}
+
+func crc32Castagnoli(l BigIntList) (*big.Int, error) {
+	b, err := listToBytes(l)
+	if err != nil {
+		return nil, err
+	}
+	return big.NewInt(int64(crc32.Checksum(b, castagnoliTable))), nil
+}
+
+// crcGeneric computes a CRC of the given width (1 to 64 bits) over data, one bit at a
+// time. If reflect is true each input byte is processed least-significant bit first and
+// the final CRC is bit-reversed, as is done for the common reflected CRCs such as
+// the IEEE CRC-32.
+func crcGeneric(data []byte, width uint, poly, init uint64, reflect bool, xorout uint64) uint64 {
+	mask := ^uint64(0) >> (64 - width)
+	crc := init & mask
+	for _, b := range data {
+		for i := uint(0); i < 8; i++ {
+			var bit uint64
+			if reflect {
+				bit = uint64(b>>i) & 1
+			} else {
+				bit = uint64(b>>(7-i)) & 1
+			}
+			top := (crc >> (width - 1)) & 1
+			crc = (crc << 1) & mask
+			if top^bit == 1 {
+				crc ^= poly & mask
+			}
+		}
+	}
+
+	if reflect {
+		var r uint64
+		for i := uint(0); i < width; i++ {
+			r = (r << 1) | (crc>>i)&1
+		}
+		crc = r
+	}
+
+	return (crc ^ xorout) & mask
+}
+
+func toUint64(n *big.Int, name string) (uint64, error) {
+	if n.Sign() < 0 || n.BitLen() > 64 {
+		return 0, fmt.Errorf("%s must fit in 64 bits", name)
+	}
+	return n.Uint64(), nil
+}
+
+func crcList(l BigIntList, width, poly, init, reflect, xorout *big.Int) (*big.Int, error) {
+	b, err := listToBytes(l)
+	if err != nil {
+		return nil, err
+	}
+
+	if width.Sign() <= 0 || width.Cmp(big.NewInt(64)) > 0 {
+		return nil, fmt.Errorf("CRC width must be between 1 and 64")
+	}
+	w := uint(width.Uint64())
+
+	p, err := toUint64(poly, "polynomial")
+	if err != nil {
+		return nil, err
+	}
+	i, err := toUint64(init, "initial value")
+	if err != nil {
+		return nil, err
+	}
+	x, err := toUint64(xorout, "final xor value")
+	if err != nil {
+		return nil, err
+	}
+
+	return big.NewInt(0).SetUint64(crcGeneric(b, w, p, i, reflect.Sign() != 0, x)), nil
+}
+
+func crc16(l BigIntList, poly *big.Int) (*big.Int, error) {
+	return crcList(l, big.NewInt(16), poly, big.NewInt(0), big.NewInt(0), big.NewInt(0))
+}
+
+func crc8(l BigIntList, poly *big.Int) (*big.Int, error) {
+	return crcList(l, big.NewInt(8), poly, big.NewInt(0), big.NewInt(0), big.NewInt(0))
+}
+
+func adler32List(l BigIntList) (*big.Int, error) {
+	b, err := listToBytes(l)
+	if err != nil {
+		return nil, err
+	}
+	return big.NewInt(int64(adler32.Checksum(b))), nil
+}
+
+// digitsOf returns the decimal digits of n, least significant first.
+func digitsOf(n *big.Int) ([]int, error) {
+	if n.Sign() < 0 {
+		return nil, fmt.Errorf("Number must not be negative")
+	}
+	s := n.String()
+	d := make([]int, len(s))
+	for i := range s {
+		d[i] = int(s[len(s)-1-i] - '0')
+	}
+	return d, nil
+}
+
+// luhn returns the Luhn check digit that should be appended to n.
+func luhn(n *big.Int) (*big.Int, error) {
+	d, err := digitsOf(n)
+	if err != nil {
+		return nil, err
+	}
+	sum := 0
+	for i, v := range d {
+		if i%2 == 0 {
+			v *= 2
+			if v > 9 {
+				v -= 9
+			}
+		}
+		sum += v
+	}
+	return big.NewInt(int64((10 - sum%10) % 10)), nil
+}
+
+// isbn10 returns the check digit for the first nine digits of an ISBN-10. A check digit of 10
+// is written as X.
+func isbn10(n *big.Int) (*big.Int, error) {
+	d, err := digitsOf(n)
+	if err != nil {
+		return nil, err
+	}
+	if len(d) > 9 {
+		return nil, fmt.Errorf("ISBN-10 check digit is computed from 9 digits")
+	}
+	sum := 0
+	for i, v := range d {
+		sum += v * (i + 2)
+	}
+	return big.NewInt(int64((11 - sum%11) % 11)), nil
+}
+
+// isbn13 returns the check digit for the first twelve digits of an ISBN-13.
+func isbn13(n *big.Int) (*big.Int, error) {
+	d, err := digitsOf(n)
+	if err != nil {
+		return nil, err
+	}
+	if len(d) > 12 {
+		return nil, fmt.Errorf("ISBN-13 check digit is computed from 12 digits")
+	}
+	sum := 0
+	for i, v := range d {
+		if i%2 == 0 {
+			v *= 3
+		}
+		sum += v
+	}
+	return big.NewInt(int64((10 - sum%10) % 10)), nil
+}
+
+func registerHashes() {
+	RegisterBuiltin("crc32", crc32IEEE, "return the IEEE CRC-32 of the list of bytes p1")
+	RegisterBuiltin("crc32c", crc32Castagnoli, "return the Castagnoli CRC-32 of the list of bytes p1")
+	RegisterBuiltin("crc16", crc16, "return the 16 bit CRC of the list of bytes p1 using the polynomial p2, with initial value 0 and no reflection (0x1021 gives CRC-16/XMODEM)")
+	RegisterBuiltin("crc8", crc8, "return the 8 bit CRC of the list of bytes p1 using the polynomial p2, with initial value 0 and no reflection (0x07 gives CRC-8/SMBUS)")
+	RegisterBuiltin("crc", crcList, "return the CRC of the list of bytes p1 having width p2 bits, polynomial p3, initial value p4, reflecting input and output if p5 is nonzero, and xoring the result with p6")
+	RegisterBuiltin("adler32", adler32List, "return the Adler-32 checksum of the list of bytes p1")
+	RegisterBuiltin("fnv1a32", hashList(func() hash.Hash { return fnv.New32a() }), "return the 32 bit FNV-1a hash of the list of bytes p1")
+	RegisterBuiltin("fnv1a64", hashList(func() hash.Hash { return fnv.New64a() }), "return the 64 bit FNV-1a hash of the list of bytes p1")
+	RegisterBuiltin("md5", hashList(md5.New), "return the MD5 digest of the list of bytes p1")
+	RegisterBuiltin("sha1", hashList(sha1.New), "return the SHA-1 digest of the list of bytes p1")
+	RegisterBuiltin("sha256", hashList(sha256.New), "return the SHA-256 digest of the list of bytes p1")
+	RegisterBuiltin("sha512", hashList(sha512.New), "return the SHA-512 digest of the list of bytes p1")
+	RegisterBuiltin("luhn", luhn, "return the Luhn check digit for the number p1")
+	RegisterBuiltin("isbn10", isbn10, "return the check digit for the first nine digits p1 of an ISBN-10. 10 represents X")
+	RegisterBuiltin("isbn13", isbn13, "return the check digit for the first twelve digits p1 of an ISBN-13")
+}
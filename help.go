@@ -1,42 +1,265 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
 	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/chzyer/readline"
 )
 
 type NumParamer interface {
 	NumParams() int
 }
 
-func printFuncHelp() {
-	keys := make([]string, len(Funcs))
-	i := 0
-	for k := range Funcs {
-		keys[i] = k
-		i++
-	}
-	sort.Strings(keys)
-
-	for _, k := range keys {
-		v := Funcs[k]
-		p, ok := v.(NumParamer)
-		if ok {
-			fmt.Printf("%s(", k)
-			num := p.NumParams()
-			if num >= 0 {
-				for i := 0; i < p.NumParams(); i++ {
-					if i > 0 {
-						fmt.Printf(", ")
-					}
-					fmt.Printf("p%d", i+1)
-				}
-			} else {
-				fmt.Printf("...")
+// operatorAliases maps operators that are written differently from the function that
+// implements them to the function's name.
+var operatorAliases = map[string]string{
+	"<<": "lsh",
+	">>": "rsh",
+}
+
+// funcParams returns the names of the parameters of f. Functions that don't declare
+// names have parameters named p1, p2 and so on.
+func funcParams(f Func) []string {
+	switch t := f.(type) {
+	case *DefinedFunc:
+		return t.paramNames
+	case *BuiltinFunc:
+		if t.params != nil {
+			return t.params
+		}
+	}
+
+	p, ok := f.(NumParamer)
+	if !ok || p.NumParams() < 0 {
+		return []string{"..."}
+	}
+	names := make([]string, p.NumParams())
+	for i := range names {
+		names[i] = fmt.Sprintf("p%d", i+1)
+	}
+	return names
+}
+
+// funcCategory returns the category that f is listed under in help.
+func funcCategory(f Func) string {
+	switch t := f.(type) {
+	case *DefinedFunc:
+		return "user"
+	case *BuiltinFunc:
+		if t.category != "" {
+			return t.category
+		}
+	}
+	return "other"
+}
+
+var funcType = reflect.TypeOf((*Func)(nil)).Elem()
+
+// paramTypeName returns the name of the type of values that a parameter of type t
+// accepts.
+func paramTypeName(t reflect.Type) string {
+	switch {
+	case t == funcType:
+		return "function"
+	case t.Kind() == reflect.Interface:
+		return "any"
+	}
+	return typeName(reflect.Zero(t).Interface())
+}
+
+// signature returns how f is called, such as lsh(a, n). If withTypes is true the types
+// of the parameters of builtin functions are included, such as lsh(a any, n int).
+func signature(name string, f Func, withTypes bool) string {
+	params := funcParams(f)
+	if bf, ok := f.(*BuiltinFunc); ok && withTypes {
+		typed := make([]string, len(params))
+		for i, p := range params {
+			typed[i] = p
+			switch {
+			case p == "...":
+				typed[i] = fmt.Sprintf("... %s", paramTypeName(bf.typ.In(bf.typ.NumIn()-1).Elem()))
+			case bf.typ.IsVariadic() && i >= bf.typ.NumIn()-1:
+				typed[i] = fmt.Sprintf("%s %s", p, paramTypeName(bf.typ.In(bf.typ.NumIn()-1).Elem()))
+			case i < bf.typ.NumIn():
+				typed[i] = fmt.Sprintf("%s %s", p, paramTypeName(bf.typ.In(i)))
 			}
-			fmt.Printf("): %s\n", v.Help())
-		} else {
-			fmt.Printf("%s: %s\n", k, v.Help())
 		}
+		params = typed
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(params, ", "))
+}
+
+var paramRef = regexp.MustCompile(`\bp([1-9])\b`)
+
+// helpText returns the help of f with the references p1, p2 and so on to its parameters
+// replaced by the parameters' names.
+func helpText(f Func) string {
+	params := funcParams(f)
+	return paramRef.ReplaceAllStringFunc(f.Help(), func(ref string) string {
+		i := int(ref[1] - '1')
+		if i < len(params) && params[i] != "..." {
+			return params[i]
+		}
+		return ref
+	})
+}
+
+// sortedFuncNames returns the names of the functions in each category, sorted.
+func sortedFuncNames() map[string][]string {
+	byCategory := map[string][]string{}
+	for k, f := range Funcs {
+		c := funcCategory(f)
+		byCategory[c] = append(byCategory[c], k)
+	}
+	for _, names := range byCategory {
+		sort.Strings(names)
+	}
+	return byCategory
+}
+
+// categoryNames returns the names of the categories in the order that help lists them.
+func categoryNames() []string {
+	var names []string
+	for _, c := range builtinCategories {
+		names = append(names, c.name)
+	}
+	return append(names, "other", "user")
+}
+
+func writeSummary(w *tabwriter.Writer, name string, f Func) {
+	fmt.Fprintf(w, "  %s\t%s\n", signature(name, f, false), helpText(f))
+}
+
+// printFuncHelp prints every function, grouped by category.
+func printFuncHelp() {
+	printCategories(categoryNames())
+}
+
+func printCategories(categories []string) {
+	var buf bytes.Buffer
+	byCategory := sortedFuncNames()
+	for _, c := range categories {
+		names := byCategory[c]
+		if len(names) == 0 {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(&buf, "%s:\n", c)
+		w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+		for _, k := range names {
+			writeSummary(w, k, Funcs[k])
+		}
+		w.Flush()
+	}
+	page(buf.String())
+}
+
+// printTopicHelp prints the help for topic, which is a function, a variable holding a
+// function, an operator, or a category.
+func printTopicHelp(topic string) error {
+	for _, c := range categoryNames() {
+		if topic == c {
+			printCategories([]string{c})
+			return nil
+		}
+	}
+
+	name := topic
+	if alias, ok := operatorAliases[topic]; ok {
+		name = alias
+	}
+	f, ok := Funcs[name]
+	if !ok {
+		if v, found := GlobalVars[name]; found {
+			f, ok = v.(Func)
+		}
+	}
+	if !ok {
+		return ErrNoSuchFunc{topic}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n", signature(name, f, true))
+	if h := helpText(f); h != "" {
+		fmt.Fprintf(&buf, "    %s\n", h)
+	}
+	fmt.Fprintf(&buf, "    category: %s\n", funcCategory(f))
+	switch t := f.(type) {
+	case *BuiltinFunc:
+		if t.result != "" {
+			fmt.Fprintf(&buf, "    example: %s = %s\n", t.example, t.result)
+		} else if t.example != "" {
+			fmt.Fprintf(&buf, "    example: %s\n", t.example)
+		}
+	case *DefinedFunc:
+		fmt.Fprintf(&buf, "    %s\n", funcSource(t))
+	}
+	page(buf.String())
+	return nil
+}
+
+// apropos prints the functions whose name or help contains word, ignoring case.
+func apropos(word string) {
+	word = strings.ToLower(word)
+
+	var names []string
+	for k, f := range Funcs {
+		if strings.Contains(strings.ToLower(k), word) || strings.Contains(strings.ToLower(helpText(f)), word) {
+			names = append(names, k)
+		}
+	}
+	if len(names) == 0 {
+		fmt.Fprintf(textOut, "No functions match '%s'\n", word)
+		return
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	for _, k := range names {
+		writeSummary(w, k, Funcs[k])
+	}
+	w.Flush()
+	page(buf.String())
+}
+
+// page prints text, through a pager if it is longer than the terminal is high. The pager
+// is $PAGER, or less.
+func page(text string) {
+	fd := int(os.Stdout.Fd())
+	if bool(jsonOutput) || !readline.IsTerminal(fd) {
+		fmt.Fprint(textOut, text)
+		return
+	}
+	_, height, err := readline.GetSize(fd)
+	if err != nil || strings.Count(text, "\n") < height {
+		fmt.Print(text)
+		return
+	}
+
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if os.Getenv("LESS") == "" {
+		// Quit if the text fits after all, and keep it on the screen after quitting
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	if err := cmd.Run(); err != nil {
+		fmt.Print(text)
 	}
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const hexdumpWidth = 16
+
+// listToBytes converts a list of ints, each of which must be in the range 0-255,
+// to a byte slice.
+func listToBytes(l BigIntList) ([]byte, error) {
+	b := make([]byte, len(l))
+	for i, v := range l {
+		if v.Sign() < 0 || v.Cmp(big.NewInt(255)) > 0 {
+			return nil, fmt.Errorf("Value at index %d is too large for byte", i)
+		}
+		b[i] = byte(v.Uint64())
+	}
+	return b, nil
+}
+
+func bytesToList(b []byte) BigIntList {
+	l := make(BigIntList, len(b))
+	for i, v := range b {
+		l[i] = big.NewInt(int64(v))
+	}
+	return l
+}
+
+// hexdump formats a list of bytes in the same style as `hexdump -C`: an offset,
+// sixteen bytes in hex split into two groups of eight, and the printable ASCII
+// characters.
+func hexdump(l BigIntList) (string, error) {
+	b, err := listToBytes(l)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for off := 0; off < len(b); off += hexdumpWidth {
+		end := off + hexdumpWidth
+		if end > len(b) {
+			end = len(b)
+		}
+		line := b[off:end]
+
+		fmt.Fprintf(&buf, "%08x ", off)
+		for i := 0; i < hexdumpWidth; i++ {
+			if i%8 == 0 {
+				buf.WriteByte(' ')
+			}
+			if i < len(line) {
+				fmt.Fprintf(&buf, "%02x ", line[i])
+			} else {
+				buf.WriteString("   ")
+			}
+		}
+
+		buf.WriteString(" |")
+		for _, c := range line {
+			if c >= 0x20 && c < 0x7f {
+				buf.WriteByte(c)
+			} else {
+				buf.WriteByte('.')
+			}
+		}
+		buf.WriteString("|\n")
+	}
+	fmt.Fprintf(&buf, "%08x", len(b))
+
+	return buf.String(), nil
+}
+
+// unhexdump parses the text of a hex dump back into a list of bytes. It understands
+// the output of `hexdump -C`, `xxd` and gdb's `x/xb` command. Lines may be separated
+// by newlines or semicolons.
+func unhexdump(s string) (BigIntList, error) {
+	var b []byte
+
+	lines := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '\n' || r == ';'
+	})
+
+	for i, line := range lines {
+		lb, err := parseHexdumpLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("hex dump line %d: %v", i+1, err)
+		}
+		b = append(b, lb...)
+	}
+
+	return bytesToList(b), nil
+}
+
+func parseHexdumpLine(line string) ([]byte, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	if fields[0] == "*" {
+		return nil, fmt.Errorf("repeated lines marked with '*' are not supported")
+	}
+
+	// xxd and gdb terminate the offset or address with a colon. gdb may also
+	// include a symbol like <buf+16> between the address and the colon.
+	addrEnd := -1
+	if strings.HasSuffix(fields[0], ":") {
+		addrEnd = strings.Index(line, ":")
+	} else if len(fields) > 1 && strings.HasPrefix(fields[1], "<") && strings.HasSuffix(fields[1], ">:") {
+		addrEnd = strings.Index(line, ">:") + 1
+	}
+
+	if addrEnd >= 0 {
+		// xxd separates the ASCII column from the hex using two spaces.
+		line = strings.TrimLeft(line[addrEnd+1:], " \t")
+		if i := strings.Index(line, "  "); i >= 0 {
+			line = line[:i]
+		}
+	} else {
+		// hexdump -C: the offset is the first field and the ASCII column is
+		// enclosed in pipes.
+		line = strings.TrimLeft(line, " \t")[len(fields[0]):]
+		if i := strings.IndexByte(line, '|'); i >= 0 {
+			line = line[:i]
+		}
+	}
+
+	var b []byte
+	for _, f := range strings.Fields(line) {
+		f = strings.TrimPrefix(strings.TrimPrefix(f, "0x"), "0X")
+		fb, err := hex.DecodeString(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex value '%s'", f)
+		}
+		b = append(b, fb...)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chzyer/readline"
+)
+
+type tokenKind int
+
+const (
+	tokNumber tokenKind = iota
+	tokString
+	tokName
+	tokOperator
+	tokBracket
+	tokComment
+	tokOther
+)
+
+// token is a token of the input. start and end are byte offsets.
+type token struct {
+	kind       tokenKind
+	start, end int
+}
+
+func newToken(kind tokenKind, c *current) token {
+	return token{kind, c.pos.offset, c.pos.offset + len(c.text)}
+}
+
+// tokenize splits line into tokens using the Tokens rule of the grammar.
+func tokenize(line string) []token {
+	r, err := Parse("tokens", []byte(line), Entrypoint("Tokens"))
+	if err != nil {
+		return nil
+	}
+	l, _ := r.([]token)
+	return l
+}
+
+// colorOutput selects highlighting the input as it's typed. It is off if the NO_COLOR
+// environment variable is set.
+var colorOutput boolSetting = os.Getenv("NO_COLOR") == ""
+
+// colorScheme maps the classes of highlighted text to the SGR parameters of the escape
+// sequence that colours them, such as 34 for blue.
+type colorScheme map[string]string
+
+var colors = colorScheme{
+	"number":       "36",
+	"string":       "32",
+	"operator":     "33",
+	"function":     "34",
+	"variable":     "35",
+	"unknown":      "31",
+	"keyword":      "1",
+	"comment":      "2",
+	"match":        "7",
+	"error":        "41",
+	"preview":      "2",
+	"previewerror": "2;31",
+}
+
+// paintColors is a copy of colors for the highlighter and the preview. Readline calls
+// them from its own goroutine, while set colors changes colors on the one that
+// evaluates. The REPL takes a new snapshot before reading each line.
+var paintColors struct {
+	sync.Mutex
+	scheme colorScheme
+}
+
+// takeColorSnapshot copies colors for the highlighter. It must be called from the
+// goroutine that evaluates.
+func takeColorSnapshot() {
+	s := make(colorScheme, len(colors))
+	for k, v := range colors {
+		s[k] = v
+	}
+	paintColors.Lock()
+	paintColors.scheme = s
+	paintColors.Unlock()
+}
+
+// currentColors returns the latest snapshot of colors.
+func currentColors() colorScheme {
+	paintColors.Lock()
+	defer paintColors.Unlock()
+	return paintColors.scheme
+}
+
+var colorNames = map[string]string{
+	"none":    "",
+	"bold":    "1",
+	"dim":     "2",
+	"reverse": "7",
+	"black":   "30",
+	"red":     "31",
+	"green":   "32",
+	"yellow":  "33",
+	"blue":    "34",
+	"magenta": "35",
+	"cyan":    "36",
+	"white":   "37",
+}
+
+// Set changes the colours of the classes listed in v, such as "number=cyan,keyword=bold+blue".
+// A colour is names from colorNames or SGR parameters, joined by +.
+func (s colorScheme) Set(v string) error {
+	changed := colorScheme{}
+	for _, kv := range strings.Split(v, ",") {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return fmt.Errorf("expected class=color, such as number=cyan")
+		}
+		class := kv[:i]
+		if _, ok := s[class]; !ok {
+			return fmt.Errorf("unknown class %s; must be one of %s", class, strings.Join(s.classes(), ", "))
+		}
+		var params []string
+		for _, c := range strings.Split(kv[i+1:], "+") {
+			if sgr, ok := colorNames[c]; ok {
+				c = sgr
+			} else if _, err := strconv.ParseUint(c, 10, 8); err != nil {
+				return fmt.Errorf("invalid color %s; use a color name such as blue or an SGR parameter such as 34", c)
+			}
+			if c != "" {
+				params = append(params, c)
+			}
+		}
+		changed[class] = strings.Join(params, ";")
+	}
+	for k, v := range changed {
+		s[k] = v
+	}
+	return nil
+}
+
+func (s colorScheme) String() string {
+	l := make([]string, 0, len(s))
+	for _, k := range s.classes() {
+		c := strings.ReplaceAll(s[k], ";", "+")
+		if c == "" {
+			c = "none"
+		}
+		l = append(l, k+"="+c)
+	}
+	return strings.Join(l, ",")
+}
+
+func (s colorScheme) Values() []string {
+	l := s.classes()
+	for i := range l {
+		l[i] += "="
+	}
+	return l
+}
+
+func (s colorScheme) classes() []string {
+	l := make([]string, 0, len(s))
+	for k := range s {
+		l = append(l, k)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// highlighter implements readline.Painter. It colours the tokens of the line, the
+// bracket that matches the one at the cursor, and brackets that aren't matched, which
+// warns about an unclosed bracket before the line is entered. With the preview setting
+// on it also shows the result of the line below it.
+type highlighter struct {
+	// prompt is the prompt that the line is edited after.
+	prompt string
+	// pending is the text of the earlier lines of a statement that continues on the
+	// line being edited, so that brackets are matched across lines.
+	pending string
+}
+
+func (h *highlighter) Paint(line []rune, pos int) []rune {
+	painted := h.colorize(line, pos)
+	// The line ends with a newline once ENTER has been pressed
+	if !preview || (len(line) > 0 && line[len(line)-1] == '\n') {
+		return painted
+	}
+
+	code, _ := scanLine(string(line))
+	if h.pending != "" {
+		code = h.pending + " " + code
+	}
+	text, isErr := previewLine(code)
+	if text == "" {
+		return painted
+	}
+	// When the line ends at the edge of the screen the cursor waits to wrap, which moving
+	// it to show the preview would lose.
+	width := readline.GetScreenWidth()
+	if width > 0 && (readline.Runes{}).WidthAll([]rune(h.prompt+string(line)))%width == 0 {
+		return painted
+	}
+	return append(painted, []rune(previewHint(text, isErr, width))...)
+}
+
+// colorize returns line with its tokens coloured.
+func (h *highlighter) colorize(line []rune, pos int) []rune {
+	if !colorOutput {
+		return line
+	}
+	prefix := ""
+	if h.pending != "" {
+		prefix = h.pending + " "
+	}
+	s := prefix + string(line)
+	toks := tokenize(s)
+	if toks == nil {
+		return line
+	}
+
+	classes := make([]string, len(toks))
+	classifyNames(s, toks, classes)
+	for i, t := range toks {
+		switch t.kind {
+		case tokNumber:
+			classes[i] = "number"
+		case tokString:
+			classes[i] = "string"
+		case tokOperator:
+			classes[i] = "operator"
+		case tokComment:
+			classes[i] = "comment"
+		}
+	}
+	matchBrackets(s, toks, classes, len(prefix)+len(string(line[:pos])))
+
+	scheme := currentColors()
+	var buf strings.Builder
+	last := len(prefix)
+	for i, t := range toks {
+		if t.start < len(prefix) {
+			continue
+		}
+		buf.WriteString(s[last:t.start])
+		if sgr := scheme[classes[i]]; sgr != "" {
+			fmt.Fprintf(&buf, "\x1b[%sm%s\x1b[0m", sgr, s[t.start:t.end])
+		} else {
+			buf.WriteString(s[t.start:t.end])
+		}
+		last = t.end
+	}
+	buf.WriteString(s[last:])
+	return []rune(buf.String())
+}
+
+// classifyNames sets the classes of the name tokens in toks: keywords, functions,
+// variables, or unknown names, as of the latest snapshot of the names. The function
+// being defined is a function, and its parameters and the variable being assigned are
+// variables. The operands of statements other than exprStatements aren't expressions,
+// so they are left plain.
+func classifyNames(s string, toks []token, classes []string) {
+	names := currentNames()
+	stmtStart := 0
+	var params map[string]bool
+	for i, t := range toks {
+		text := s[t.start:t.end]
+		if t.kind == tokOperator && text == ";" {
+			stmtStart = i + 1
+			params = nil
+			continue
+		}
+		if t.kind != tokName {
+			continue
+		}
+
+		first := s[toks[stmtStart].start:toks[stmtStart].end]
+		switch {
+		case text == "def":
+			classes[i] = "keyword"
+			params = defParams(s, toks[i+1:])
+		case i == stmtStart && isKeyword(text) && !usedAsName(s, toks, i):
+			classes[i] = "keyword"
+		case i > 0 && s[toks[i-1].start:toks[i-1].end] == "def":
+			// The function being defined
+			classes[i] = "function"
+		case !exprStatements[first] && isKeyword(first):
+			// The operands of statements such as set and help
+		case params[text]:
+			classes[i] = "variable"
+		case i == stmtStart && i+1 < len(toks) && s[toks[i+1].start:toks[i+1].end] == "=":
+			classes[i] = "variable"
+		default:
+			if names.vars[text] {
+				classes[i] = "variable"
+			} else if _, ok := names.funcs[text]; ok {
+				classes[i] = "function"
+			} else {
+				classes[i] = "unknown"
+			}
+		}
+	}
+}
+
+// exprStatements are the statements whose operands are highlighted as an expression.
+var exprStatements = map[string]bool{"def": true, "explain": true, "time": true, "bench": true}
+
+// usedAsName returns true if toks[i], one of exprStatements, is a name in an expression
+// rather than a statement, as the grammar decides in ExprStmtOperand: when it isn't
+// followed by a space, or is followed by = or a binary operator.
+func usedAsName(s string, toks []token, i int) bool {
+	if !exprStatements[s[toks[i].start:toks[i].end]] || i+1 >= len(toks) {
+		return false
+	}
+	next := toks[i+1]
+	if next.start == toks[i].end {
+		return true
+	}
+	text := s[next.start:next.end]
+	if next.kind != tokOperator {
+		return false
+	}
+	if text == "-" {
+		return next.end < len(s) && (s[next.end] == ' ' || s[next.end] == '\t')
+	}
+	return strings.ContainsAny(text[:1], "=^*/&+|<>")
+}
+
+func isKeyword(s string) bool {
+	for _, k := range keywords {
+		if s == k {
+			return true
+		}
+	}
+	return false
+}
+
+// defParams returns the parameter names in the tokens following def: an optional
+// function name, then the parameters in parentheses.
+func defParams(s string, toks []token) map[string]bool {
+	params := map[string]bool{}
+	inParams := false
+	for _, t := range toks {
+		text := s[t.start:t.end]
+		switch {
+		case text == "(":
+			inParams = true
+		case text == ")":
+			return params
+		case inParams && t.kind == tokName:
+			params[text] = true
+		}
+	}
+	return params
+}
+
+var closing = map[string]string{"(": ")", "[": "]", "{": "}"}
+
+// matchBrackets sets the class of brackets in toks that aren't matched to error, and of
+// the bracket at or just before the cursor offset and its match to match.
+func matchBrackets(s string, toks []token, classes []string, cursor int) {
+	var open []int
+	match := map[int]int{}
+	for i, t := range toks {
+		if t.kind != tokBracket {
+			continue
+		}
+		text := s[t.start:t.end]
+		if _, ok := closing[text]; ok {
+			open = append(open, i)
+			continue
+		}
+		if len(open) == 0 || closing[s[toks[open[len(open)-1]].start:toks[open[len(open)-1]].end]] != text {
+			classes[i] = "error"
+			continue
+		}
+		j := open[len(open)-1]
+		open = open[:len(open)-1]
+		match[i], match[j] = j, i
+	}
+	for _, i := range open {
+		classes[i] = "error"
+	}
+
+	for i, t := range toks {
+		if t.kind != tokBracket || (t.start != cursor && t.end != cursor) {
+			continue
+		}
+		if j, ok := match[i]; ok {
+			classes[i], classes[j] = "match", "match"
+			return
+		}
+	}
+}
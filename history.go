@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// historySize is the number of inputs kept in the history file.
+var historySize = 1000
+
+// inputHistory is the history of lines entered at the prompt, which is kept in a file
+// so that it is available in later sessions. Each line appears only once, at the
+// position it was last entered.
+type inputHistory struct {
+	path  string
+	lines []string
+}
+
+// historyPath returns the path of the file that the input history is kept in.
+func historyPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "calc", "history"), nil
+}
+
+// loadInputHistory reads the input history from the file at path. A missing file is an
+// empty history.
+func loadInputHistory(path string) (*inputHistory, error) {
+	h := &inputHistory{path: path}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	defer file.Close()
+
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			h.push(line)
+		}
+	}
+	return h, sc.Err()
+}
+
+// push appends line to the history, removing an earlier copy of it and the oldest lines
+// that exceed the size of the history.
+func (h *inputHistory) push(line string) {
+	for i, l := range h.lines {
+		if l == line {
+			h.lines = append(h.lines[:i], h.lines[i+1:]...)
+			break
+		}
+	}
+	h.lines = append(h.lines, line)
+	if historySize > 0 && len(h.lines) > historySize {
+		h.lines = h.lines[len(h.lines)-historySize:]
+	}
+}
+
+// add appends line to the history and writes the history to its file.
+func (h *inputHistory) add(line string) error {
+	h.push(line)
+	return h.save()
+}
+
+func (h *inputHistory) save() error {
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+
+	// Replace the file so that a failed write doesn't lose the history
+	tmp := h.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, l := range h.lines {
+		fmt.Fprintln(w, l)
+	}
+	if err = w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// An entry in the numbered history of the inputs evaluated at the prompt.
+type historyEntry struct {
+	input  string
+	result interface{}
+	err    error
+	// dropped is set when the result is no longer kept
+	dropped bool
+}
+
+// entries holds the inputs evaluated at the prompt. Input n is entries[n-1].
+var entries []historyEntry
+
+// recordEntry adds the input and its result, or the error it failed with, to the
+// numbered history. The result of input n is stored in the variables _n and $n; entries
+// are only recorded at the prompt, where there are no script arguments for $n to clash
+// with. Like the input history, only the results of the last historySize inputs are
+// kept.
+func recordEntry(input string, parsed interface{}, err error) {
+	e := historyEntry{input: input, err: err}
+	if err == nil {
+		e.result = lastValue(parsed)
+	}
+	entries = append(entries, e)
+
+	n := len(entries)
+	if e.result != nil {
+		GlobalVars[fmt.Sprintf("$%d", n)] = e.result
+		SetGlobal(fmt.Sprintf("_%d", n), e.result)
+	}
+	if historySize <= 0 {
+		return
+	}
+	// The setting may have been lowered since the last input
+	for i := n - historySize - 1; i >= 0 && !entries[i].dropped; i-- {
+		entries[i].result, entries[i].dropped = nil, true
+		delete(GlobalVars, fmt.Sprintf("$%d", i+1))
+		delete(GlobalVars, fmt.Sprintf("_%d", i+1))
+	}
+}
+
+// isResultName returns true if name is one of the variables that hold numbered results.
+func isResultName(name string) bool {
+	return len(name) > 1 && name[0] == '_' && strings.Trim(name[1:], "0123456789") == ""
+}
+
+// out returns the result of input number n. Negative numbers count back from the latest
+// input, so out(-1) is the previous result.
+func out(n *big.Int) (interface{}, error) {
+	i := int(n.Int64())
+	if !n.IsInt64() || i == 0 || i > len(entries) || -i > len(entries) {
+		return nil, fmt.Errorf("there is no input number %s", n)
+	}
+	if i < 0 {
+		i += len(entries) + 1
+	}
+
+	e := entries[i-1]
+	if e.dropped {
+		return nil, fmt.Errorf("the result of input number %d is no longer kept; see the histsize setting", i)
+	}
+	if e.result == nil {
+		return nil, fmt.Errorf("input number %d has no result", i)
+	}
+	return clone(e.result), nil
+}
+
+// printHistory prints the numbered inputs with their results.
+func printHistory() {
+	for i, e := range entries {
+		fmt.Fprintf(textOut, "%4d  %s\n", i+1, e.input)
+		switch {
+		case e.err != nil:
+			fmt.Fprintf(textOut, "      Error: %s\n", errorMessage(firstError(e.err)))
+		case e.result != nil:
+			fmt.Fprintf(textOut, "      = %s\n", formatValue(e.result))
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrInterrupted is returned when an evaluation is cancelled, for example by pressing CTRL-C.
+var ErrInterrupted = errors.New("Interrupted")
+
+type ErrTimeout struct {
+	Timeout time.Duration
+}
+
+func (e ErrTimeout) Error() string {
+	return fmt.Sprintf("Evaluation timed out after %v", e.Timeout)
+}
+
+// durationSetting is a setting holding a length of time. It accepts durations such as
+// 500ms or 2m, plain numbers of seconds, and "off".
+type durationSetting time.Duration
+
+func (d *durationSetting) Set(s string) error {
+	if s == "off" || s == "0" {
+		*d = 0
+		return nil
+	}
+
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs > 0 {
+		*d = durationSetting(secs * float64(time.Second))
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil || v <= 0 {
+		return fmt.Errorf("invalid duration %s; use a value such as 500ms, 10s or 2m, or 'off'", s)
+	}
+	*d = durationSetting(v)
+	return nil
+}
+
+func (d durationSetting) Values() []string {
+	return []string{"off"}
+}
+
+func (d durationSetting) String() string {
+	if d == 0 {
+		return "off"
+	}
+	return time.Duration(d).String()
+}
+
+// timeout limits how long evaluating a line may take. It is off by default.
+var timeout durationSetting
+
+// evalCtx is the context of the evaluation in progress. Evaluation happens while parsing,
+// through the global state of variables and functions, so rather than passing the context
+// through the parser it's held here while EvalContext runs.
+var evalCtx = context.Background()
+
+// evalStart is the time at which the evaluation in progress started.
+var evalStart time.Time
+
+// EvalContext parses and evaluates b like Parse, but stops with an error when ctx is
+// cancelled or the timeout setting elapses. The timeout is checked as evaluation
+// proceeds, so setting it earlier on the same line takes effect. The evaluation is
+// subject to the limits given to ctx by WithLimits, if any.
+func EvalContext(ctx context.Context, filename string, b []byte, opts ...Option) (interface{}, error) {
+	outerCtx, outerStart, outerSteps, outerLimits := evalCtx, evalStart, steps, evalLimits
+	evalCtx, evalStart, steps, evalLimits = ctx, time.Now(), 0, limitsOf(ctx)
+	defer func() {
+		evalCtx, evalStart, steps, evalLimits = outerCtx, outerStart, outerSteps, outerLimits
+	}()
+
+	r, err := Parse(filename, b, opts...)
+	// Once interrupted, every remaining operation fails. Report the interruption once
+	// rather than the error from each of them.
+	if ierr := checkInterrupt(); ierr != nil {
+		return nil, ierr
+	}
+	return r, err
+}
+
+// checkInterrupt returns an error if the evaluation in progress has been cancelled. Long
+// running operations call it periodically.
+func checkInterrupt() error {
+	if evalCtx.Err() != nil {
+		return ErrInterrupted
+	}
+	if timeout > 0 && !evalStart.IsZero() && time.Since(evalStart) > time.Duration(timeout) {
+		return ErrTimeout{time.Duration(timeout)}
+	}
+	return nil
+}
+
+// interruptHandler cancels the evaluation in progress when the user presses CTRL-C.
+var interruptHandler struct {
+	sync.Mutex
+	cancel context.CancelFunc
+}
+
+func setInterruptHandler(cancel context.CancelFunc) {
+	interruptHandler.Lock()
+	interruptHandler.cancel = cancel
+	interruptHandler.Unlock()
+}
+
+func interruptEval() {
+	interruptHandler.Lock()
+	if interruptHandler.cancel != nil {
+		interruptHandler.cancel()
+	}
+	interruptHandler.Unlock()
+}
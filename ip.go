@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+)
+
+// IPAddr is an IPv4 or IPv6 address. If prefix is not negative the address is
+// part of a network written in CIDR notation, such as 10.0.0.0/8.
+type IPAddr struct {
+	n      *big.Int
+	v6     bool
+	prefix int
+}
+
+type IPAddrList []IPAddr
+
+// isIPLiteral returns true if s, text matched by the IPText rule, is an address. An IPv6
+// address without digits, such as a::b, is more likely to be a mistyped expression than
+// an address, so it's written with a zero, as 0a::b.
+func isIPLiteral(s string) bool {
+	if !strings.ContainsAny(s, ".:") {
+		return false
+	}
+	if !strings.HasPrefix(s, ":") && !strings.ContainsAny(s, "0123456789") {
+		return false
+	}
+	_, err := parseIPLiteral(s)
+	return err == nil
+}
+
+func parseIPLiteral(s string) (IPAddr, error) {
+	prefix := -1
+	addr := s
+
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return IPAddr{}, err
+		}
+		prefix, _ = ipnet.Mask.Size()
+		addr = s[:i]
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return IPAddr{}, fmt.Errorf("invalid IP address %s", s)
+	}
+
+	v6 := strings.Contains(addr, ":")
+	if !v6 {
+		ip = ip.To4()
+	}
+
+	return IPAddr{n: big.NewInt(0).SetBytes(ip), v6: v6, prefix: prefix}, nil
+}
+
+func newIPAddr(n *big.Int, v6 bool, prefix int) (IPAddr, error) {
+	a := IPAddr{n: n, v6: v6, prefix: prefix}
+	if n.Sign() < 0 || n.BitLen() > a.bits() {
+		return IPAddr{}, fmt.Errorf("IP address out of range")
+	}
+	return a, nil
+}
+
+// bits returns the number of bits in the address: 32 or 128
+func (a IPAddr) bits() int {
+	if a.v6 {
+		return 128
+	}
+	return 32
+}
+
+func (a IPAddr) IP() net.IP {
+	b := make([]byte, a.bits()/8)
+	a.n.FillBytes(b)
+	return net.IP(b)
+}
+
+func (a IPAddr) String() string {
+	if a.n == nil {
+		return "<nil>"
+	}
+	s := a.IP().String()
+	if a.prefix >= 0 {
+		s = fmt.Sprintf("%s/%d", s, a.prefix)
+	}
+	return s
+}
+
+func (a IPAddr) clone() IPAddr {
+	a.n = cloneInt(a.n)
+	return a
+}
+
+// mask returns the netmask for the address' prefix as an integer
+func (a IPAddr) mask() *big.Int {
+	prefix := a.prefix
+	if prefix < 0 {
+		prefix = a.bits()
+	}
+	m := big.NewInt(1)
+	m.Lsh(m, uint(a.bits()))
+	m.Sub(m, big.NewInt(1))
+	hostmask := big.NewInt(1)
+	hostmask.Lsh(hostmask, uint(a.bits()-prefix))
+	hostmask.Sub(hostmask, big.NewInt(1))
+	return m.Xor(m, hostmask)
+}
+
+// newIPAddrList returns the elements of a list literal as a list of addresses.
+func newIPAddrList(l []interface{}) (IPAddrList, error) {
+	r := make(IPAddrList, len(l))
+	for i, v := range l {
+		a, ok := v.(IPAddr)
+		if !ok {
+			return nil, fmt.Errorf("lists of addresses may only contain addresses, but element at index %d is %s", i, typeName(v))
+		}
+		r[i] = a
+	}
+	return r, nil
+}
+
+func listMapIPAddrList(l IPAddrList, fn Func) (IPAddrList, error) {
+	l2 := make(IPAddrList, len(l))
+	for i, a := range l {
+		v, err := fn.Call([]interface{}{a.clone()})
+		if err != nil {
+			return nil, err
+		}
+		r, ok := v.(IPAddr)
+		if !ok {
+			return nil, fmt.Errorf("Function passed to map must return the same type that the list map is being applied to contains")
+		}
+		l2[i] = r
+	}
+	return l2, nil
+}
+
+func listReduceIPAddrList(l IPAddrList, fn Func, memo IPAddr) (IPAddr, error) {
+	for _, a := range l {
+		v, err := fn.Call([]interface{}{memo, a.clone()})
+		if err != nil {
+			return IPAddr{}, err
+		}
+		r, ok := v.(IPAddr)
+		if !ok {
+			return IPAddr{}, fmt.Errorf("Function passed to reduce must return the same type that the list reduce is being applied to contains")
+		}
+		memo = r
+	}
+	return memo, nil
+}
+
+func listFilterIPAddrList(l IPAddrList, fn Func) (IPAddrList, error) {
+	l2 := make(IPAddrList, 0)
+	for _, a := range l {
+		v, err := fn.Call([]interface{}{a.clone()})
+		if err != nil {
+			return nil, err
+		}
+		t, ok := v.(*big.Int)
+		if !ok {
+			return nil, fmt.Errorf("Function passed to filter must return an integer")
+		}
+		if t.Sign() != 0 {
+			l2 = append(l2, a.clone())
+		}
+	}
+	return l2, nil
+}
+
+func listReverseIPAddrList(l IPAddrList) IPAddrList {
+	l2 := make(IPAddrList, len(l))
+	for i, a := range l {
+		l2[len(l)-i-1] = a.clone()
+	}
+	return l2
+}
+
+func (l IPAddrList) String() string {
+	var buf bytes.Buffer
+	buf.WriteRune('[')
+	for i, a := range l {
+		if i > 0 {
+			fmt.Fprintf(&buf, ", ")
+		}
+		buf.WriteString(a.String())
+	}
+	buf.WriteRune(']')
+	return buf.String()
+}
+
+func isIPValue(v interface{}) bool {
+	_, ok := v.(IPAddr)
+	return ok
+}
+
+// evalIPBinaryOp implements the operators that are defined when one of the operands is an IP
+// address: adding or subtracting an integer offset, masking with & and |, taking the
+// difference of two addresses and comparing addresses.
+func evalIPBinaryOp(op string, a, b interface{}) (interface{}, error) {
+	aip, aIsIP := a.(IPAddr)
+	bip, bIsIP := b.(IPAddr)
+
+	if aIsIP && bIsIP {
+		if aip.v6 != bip.v6 {
+			return nil, fmt.Errorf("can't mix IPv4 and IPv6 addresses")
+		}
+
+		cmp := aip.n.Cmp(bip.n)
+		var t bool
+		switch op {
+		case "-":
+			return big.NewInt(0).Sub(aip.n, bip.n), nil
+		case "<":
+			t = cmp < 0
+		case "<=":
+			t = cmp <= 0
+		case ">":
+			t = cmp > 0
+		case ">=":
+			t = cmp >= 0
+		case "=":
+			t = cmp == 0 && aip.prefix == bip.prefix
+		default:
+			return nil, fmt.Errorf("the '%s' operation is not defined for two IP addresses", op)
+		}
+		return boolToInt(t), nil
+	}
+
+	if bIsIP {
+		// Only addition is commutative among the supported operators.
+		if op != "+" && op != "&" && op != "|" {
+			return nil, fmt.Errorf("the '%s' operation is not defined for an integer and an IP address", op)
+		}
+		aip, b = bip, a
+	}
+
+	bi, ok := b.(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("IP addresses may only be combined with integers")
+	}
+
+	r := big.NewInt(0)
+	switch op {
+	case "+":
+		r.Add(aip.n, bi)
+	case "-":
+		r.Sub(aip.n, bi)
+	case "&":
+		r.And(aip.n, bi)
+	case "|":
+		r.Or(aip.n, bi)
+	default:
+		return nil, fmt.Errorf("the '%s' operation is not defined for an IP address and an integer", op)
+	}
+
+	return newIPAddr(r, aip.v6, aip.prefix)
+}
+
+func boolToInt(b bool) *big.Int {
+	if b {
+		return big.NewInt(1)
+	}
+	return big.NewInt(0)
+}
+
+func ipv4(n *big.Int) (IPAddr, error) {
+	return newIPAddr(cloneInt(n), false, -1)
+}
+
+func ipv6(n *big.Int) (IPAddr, error) {
+	return newIPAddr(cloneInt(n), true, -1)
+}
+
+func ipNum(a IPAddr) (*big.Int, error) {
+	return cloneInt(a.n), nil
+}
+
+// prefixLen returns n as a prefix length, if it's between min and max.
+func prefixLen(n *big.Int, min, max int) (int, bool) {
+	if !n.IsInt64() || n.Int64() < int64(min) || n.Int64() > int64(max) {
+		return 0, false
+	}
+	return int(n.Int64()), true
+}
+
+func ipCidr(a IPAddr, prefix *big.Int) (IPAddr, error) {
+	p, ok := prefixLen(prefix, 0, a.bits())
+	if !ok {
+		return IPAddr{}, fmt.Errorf("Prefix length must be between 0 and %d", a.bits())
+	}
+	a = a.clone()
+	a.prefix = p
+	return a, nil
+}
+
+func ipPrefixLen(a IPAddr) (*big.Int, error) {
+	if a.prefix < 0 {
+		return big.NewInt(int64(a.bits())), nil
+	}
+	return big.NewInt(int64(a.prefix)), nil
+}
+
+func ipNetwork(a IPAddr) (IPAddr, error) {
+	r := big.NewInt(0).And(a.n, a.mask())
+	return newIPAddr(r, a.v6, a.prefix)
+}
+
+func ipBroadcast(a IPAddr) (IPAddr, error) {
+	m := a.mask()
+	hostmask := big.NewInt(0).Not(m)
+	r := big.NewInt(0).And(a.n, m)
+	r.Or(r, big.NewInt(0).And(hostmask, maxIP(a.bits())))
+	return newIPAddr(r, a.v6, a.prefix)
+}
+
+func ipNetmask(a IPAddr) (IPAddr, error) {
+	return newIPAddr(a.mask(), a.v6, -1)
+}
+
+// ipHostCount returns the number of usable host addresses in the network. For IPv4
+// networks larger than a /31 the network and broadcast addresses are not counted.
+func ipHostCount(a IPAddr) (*big.Int, error) {
+	prefix := a.prefix
+	if prefix < 0 {
+		prefix = a.bits()
+	}
+	hostBits := a.bits() - prefix
+	n := big.NewInt(1)
+	n.Lsh(n, uint(hostBits))
+	if !a.v6 && hostBits > 1 {
+		n.Sub(n, big.NewInt(2))
+	}
+	return n, nil
+}
+
+func ipContains(network, a IPAddr) (*big.Int, error) {
+	if network.v6 != a.v6 {
+		return big.NewInt(0), nil
+	}
+	m := network.mask()
+	nn := big.NewInt(0).And(network.n, m)
+	an := big.NewInt(0).And(a.n, m)
+	if a.prefix >= 0 && a.prefix < network.prefix {
+		return big.NewInt(0), nil
+	}
+	return boolToInt(nn.Cmp(an) == 0), nil
+}
+
+// ipSubnets splits the network into subnets having the prefix length newPrefix.
+func ipSubnets(network IPAddr, newPrefix *big.Int) (IPAddrList, error) {
+	prefix := network.prefix
+	if prefix < 0 {
+		return nil, fmt.Errorf("%v is not a network; use CIDR notation such as 10.0.0.0/8", network)
+	}
+
+	p, ok := prefixLen(newPrefix, prefix, network.bits())
+	if !ok {
+		return nil, fmt.Errorf("Subnet prefix length must be between %d and %d", prefix, network.bits())
+	}
+
+	if p-prefix > 16 {
+		return nil, fmt.Errorf("Splitting a /%d into /%d subnets would produce too many subnets", prefix, p)
+	}
+
+	count := 1 << uint(p-prefix)
+	step := big.NewInt(1)
+	step.Lsh(step, uint(network.bits()-p))
+
+	base := big.NewInt(0).And(network.n, network.mask())
+	l := make(IPAddrList, count)
+	for i := range l {
+		l[i] = IPAddr{n: cloneInt(base), v6: network.v6, prefix: p}
+		base.Add(base, step)
+	}
+	return l, nil
+}
+
+func maxIP(bits int) *big.Int {
+	m := big.NewInt(1)
+	m.Lsh(m, uint(bits))
+	return m.Sub(m, big.NewInt(1))
+}
+
+/*** MAC addresses ***/
+
+// mac parses a MAC address string like "00:1a:2b:3c:4d:5e" into an integer.
+func mac(s string) (*big.Int, error) {
+	hw, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, err
+	}
+	return big.NewInt(0).SetBytes(hw), nil
+}
+
+// macString formats a 48-bit integer as a MAC address.
+func macString(n *big.Int) (string, error) {
+	if n.Sign() < 0 || n.BitLen() > 48 {
+		return "", fmt.Errorf("MAC address out of range")
+	}
+	b := make([]byte, 6)
+	n.FillBytes(b)
+	return net.HardwareAddr(b).String(), nil
+}
+
+// macToEUI64 converts a 48-bit MAC address to a modified EUI-64 interface identifier
+// by inserting 0xfffe in the middle and flipping the universal/local bit.
+func macToEUI64(n *big.Int) (*big.Int, error) {
+	if n.Sign() < 0 || n.BitLen() > 48 {
+		return nil, fmt.Errorf("MAC address out of range")
+	}
+	b := make([]byte, 6)
+	n.FillBytes(b)
+	e := []byte{b[0] ^ 0x02, b[1], b[2], 0xff, 0xfe, b[3], b[4], b[5]}
+	return big.NewInt(0).SetBytes(e), nil
+}
+
+// eui64ToMAC is the inverse of macToEUI64.
+func eui64ToMAC(n *big.Int) (*big.Int, error) {
+	if n.Sign() < 0 || n.BitLen() > 64 {
+		return nil, fmt.Errorf("EUI-64 identifier out of range")
+	}
+	e := make([]byte, 8)
+	n.FillBytes(e)
+	if e[3] != 0xff || e[4] != 0xfe {
+		return nil, fmt.Errorf("EUI-64 identifier was not derived from a MAC address")
+	}
+	b := []byte{e[0] ^ 0x02, e[1], e[2], e[5], e[6], e[7]}
+	return big.NewInt(0).SetBytes(b), nil
+}
+
+// ipv6EUI64 forms an IPv6 address from the /64 network and the MAC address
+// using modified EUI-64 (as in SLAAC).
+func ipv6EUI64(network IPAddr, macAddr *big.Int) (IPAddr, error) {
+	if !network.v6 {
+		return IPAddr{}, fmt.Errorf("network must be an IPv6 network")
+	}
+	id, err := macToEUI64(macAddr)
+	if err != nil {
+		return IPAddr{}, err
+	}
+	r := big.NewInt(0).Rsh(network.n, 64)
+	r.Lsh(r, 64)
+	r.Or(r, id)
+	return newIPAddr(r, true, -1)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+)
+
+// jsonOutput selects printing the result of each input as a JSON object, for programs
+// that run calc.
+var jsonOutput boolSetting
+
+// jsonWriter is where the JSON objects are written.
+var jsonWriter io.Writer = os.Stdout
+
+// textOutput is where statements such as help, vars and explain print their text. It
+// writes to stdout, or in JSON mode to stderr, so that stdout holds only JSON objects.
+type textOutput struct{}
+
+func (textOutput) Write(p []byte) (int, error) {
+	if jsonOutput {
+		return os.Stderr.Write(p)
+	}
+	return os.Stdout.Write(p)
+}
+
+var textOut io.Writer = textOutput{}
+
+// jsonResult is the JSON object printed for an input. Numbers are encoded as strings so
+// that integers of any size and floats of any precision are represented exactly.
+type jsonResult struct {
+	Input   string      `json:"input"`
+	Type    string      `json:"type,omitempty"`
+	Value   interface{} `json:"value,omitempty"`
+	Display string      `json:"display,omitempty"`
+	Error   *jsonError  `json:"error,omitempty"`
+}
+
+type jsonError struct {
+	Message string   `json:"message"`
+	Line    int      `json:"line,omitempty"`
+	Column  int      `json:"column,omitempty"`
+	Trace   []string `json:"trace,omitempty"`
+}
+
+// writeJSONResult writes the JSON object for the logical line l, which evaluated to
+// parsed or failed with err. When a line contains several statements the result of the
+// last one that has a value is written.
+func writeJSONResult(w io.Writer, l logicalLine, name string, parsed interface{}, err error) {
+	r := jsonResult{Input: l.source()}
+
+	if err != nil {
+		r.Error = newJSONError(firstError(err), l.locator(name))
+	} else {
+		v := lastValue(parsed)
+		if v != nil {
+			r.Type = typeName(v)
+			r.Value = jsonValue(v)
+			r.Display = formatValue(v)
+		}
+	}
+
+	b, jerr := json.Marshal(r)
+	if jerr != nil {
+		b, _ = json.Marshal(jsonResult{Input: r.Input, Error: &jsonError{Message: jerr.Error()}})
+	}
+	fmt.Fprintf(w, "%s\n", b)
+}
+
+func newJSONError(err error, locate locator) *jsonError {
+	pos, inner, ok := errorPosition(err)
+	e := &jsonError{Message: errorMessage(inner)}
+	if ok {
+		if loc, found := locate(pos); found {
+			e.Line, e.Column = loc.line, loc.col
+		}
+	}
+	for _, fr := range callTrace(inner) {
+		s := fr.describe()
+		if fr.Pos.line > 0 {
+			s = fmt.Sprintf("%s at %d:%d", s, fr.Pos.line, fr.Pos.col)
+		}
+		e.Trace = append(e.Trace, s)
+	}
+	return e
+}
+
+// lastValue returns the last result of the statements in parsed that has a value.
+func lastValue(parsed interface{}) interface{} {
+	l, ok := parsed.([]interface{})
+	if !ok {
+		return parsed
+	}
+	for i := len(l) - 1; i >= 0; i-- {
+		if v := lastValue(l[i]); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// jsonValue returns the value v in a form that encodes to JSON without loss.
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case *big.Int:
+		return t.String()
+	case *big.Float:
+		return t.Text('g', -1)
+	case BigIntList:
+		l := make([]string, len(t))
+		for i, n := range t {
+			l[i] = n.String()
+		}
+		return l
+	case BigFloatList:
+		l := make([]string, len(t))
+		for i, f := range t {
+			l[i] = f.Text('g', -1)
+		}
+		return l
+	case IPAddrList:
+		l := make([]string, len(t))
+		for i, a := range t {
+			l[i] = a.String()
+		}
+		return l
+	}
+	return formatValue(v)
+}
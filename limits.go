@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Limits bounds the resources that evaluating an expression may use, so that hostile
+// input can't exhaust memory or run forever. A limit of zero means unlimited.
+type Limits struct {
+	// MaxIntBits is the maximum bit length of an integer result.
+	MaxIntBits int
+	// MaxFloatPrec is the maximum precision, in bits, of a float result.
+	MaxFloatPrec int
+	// MaxListLen is the maximum number of elements in a list.
+	MaxListLen int
+	// MaxDepth is the maximum depth of nested calls of user-defined functions.
+	MaxDepth int
+	// MaxSteps is the maximum number of operations and function calls performed while
+	// evaluating one line.
+	MaxSteps int
+}
+
+// DefaultLimits are generous enough for interactive use while keeping the calculator
+// from exhausting memory or the stack.
+var DefaultLimits = Limits{
+	MaxIntBits:   1 << 27,
+	MaxFloatPrec: 1 << 24,
+	MaxListLen:   1 << 24,
+	MaxDepth:     2000,
+}
+
+var limits = DefaultLimits
+
+// SetLimits replaces the limits for the current session. It is meant for programs that
+// embed the calculator; users can change individual limits using settings.
+func SetLimits(l Limits) {
+	limits = l
+}
+
+// CurrentLimits returns the limits of the current session.
+func CurrentLimits() Limits {
+	return limits
+}
+
+type limitsKey struct{}
+
+// WithLimits returns a copy of ctx that makes EvalContext evaluate with the limits l
+// rather than those of the session, so that evaluations running for different callers
+// may have different limits.
+func WithLimits(ctx context.Context, l Limits) context.Context {
+	return context.WithValue(ctx, limitsKey{}, l)
+}
+
+// evalLimits are the limits of the evaluation in progress: those of its context, or
+// otherwise the session's, so that changing a setting earlier on the same line takes
+// effect.
+var evalLimits = &limits
+
+// limitsOf returns the limits that an evaluation using ctx is subject to.
+func limitsOf(ctx context.Context) *Limits {
+	if l, ok := ctx.Value(limitsKey{}).(Limits); ok {
+		return &l
+	}
+	return &limits
+}
+
+type ErrLimitExceeded struct {
+	What    string
+	Limit   int
+	Setting string
+}
+
+func (e ErrLimitExceeded) Error() string {
+	return fmt.Sprintf("%s exceeds the limit of %d (see 'set %s')", e.What, e.Limit, e.Setting)
+}
+
+// limitSetting is a setting for one of the limits. It accepts a positive number, or "off"
+// to remove the limit.
+type limitSetting struct {
+	v *int
+}
+
+func (s limitSetting) Set(v string) error {
+	if v == "off" {
+		*s.v = 0
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fmt.Errorf("limit must be a positive integer, or 'off'")
+	}
+	*s.v = n
+	return nil
+}
+
+func (s limitSetting) Values() []string {
+	return []string{"off"}
+}
+
+func (s limitSetting) String() string {
+	if *s.v == 0 {
+		return "off"
+	}
+	return strconv.Itoa(*s.v)
+}
+
+// steps counts the operations performed while evaluating the current line.
+var steps int
+
+// step is called for each operation and function call. It returns an error if the
+// evaluation has performed too many steps or has been interrupted.
+func step() error {
+	steps++
+	if evalLimits.MaxSteps > 0 && steps > evalLimits.MaxSteps {
+		return ErrLimitExceeded{"Number of evaluation steps", evalLimits.MaxSteps, "maxsteps"}
+	}
+	return checkInterrupt()
+}
+
+func checkIntBits(bits *big.Int) error {
+	if evalLimits.MaxIntBits > 0 && bits.Cmp(big.NewInt(int64(evalLimits.MaxIntBits))) > 0 {
+		return ErrLimitExceeded{"Integer size in bits", evalLimits.MaxIntBits, "maxintbits"}
+	}
+	return nil
+}
+
+func checkListLen(n *big.Int) error {
+	if evalLimits.MaxListLen > 0 && n.Cmp(big.NewInt(int64(evalLimits.MaxListLen))) > 0 {
+		return ErrLimitExceeded{"List length", evalLimits.MaxListLen, "maxlistlen"}
+	}
+	return nil
+}
+
+func checkDepth(depth int) error {
+	if evalLimits.MaxDepth > 0 && depth > evalLimits.MaxDepth {
+		return ErrLimitExceeded{"Depth of function calls", evalLimits.MaxDepth, "maxdepth"}
+	}
+	return nil
+}
+
+// checkResultLimits replaces the result r with an error if it exceeds the limits. It is
+// meant to be deferred.
+func checkResultLimits(r *interface{}, err *error) {
+	if *err != nil {
+		return
+	}
+	if e := checkResult(*r); e != nil {
+		*r, *err = nil, e
+	}
+}
+
+// minResultBits returns a lower bound on the bit length of the result of the integer
+// operation op, or nil if the result can't grow much larger than the operands.
+func minResultBits(op string, a, b *big.Int) *big.Int {
+	if a.Sign() == 0 {
+		return nil
+	}
+	switch op {
+	case "*":
+		if b.Sign() == 0 {
+			return nil
+		}
+		return big.NewInt(int64(a.BitLen() + b.BitLen() - 1))
+	case "^":
+		// |a|^b has at least (bitlen(a)-1)*b+1 bits
+		if b.Sign() <= 0 || a.BitLen() <= 1 {
+			return nil
+		}
+		r := big.NewInt(int64(a.BitLen() - 1))
+		return r.Mul(r, b)
+	case "<<":
+		if b.Sign() <= 0 {
+			return nil
+		}
+		return big.NewInt(0).Add(b, big.NewInt(int64(a.BitLen())))
+	}
+	return nil
+}
+
+// checkOpLimits returns an error if the result of the binary operation op on a and b
+// would exceed the limits. It is called before the operation so that the memory for a
+// huge result is never allocated.
+func checkOpLimits(op string, a, b interface{}) error {
+	switch at := a.(type) {
+	case *big.Int:
+		if bt, ok := b.(*big.Int); ok {
+			if bits := minResultBits(op, at, bt); bits != nil {
+				return checkIntBits(bits)
+			}
+		}
+	case BigIntList:
+		if bt, ok := b.(BigIntList); ok && len(at) == len(bt) {
+			for i := range at {
+				if bits := minResultBits(op, at[i], bt[i]); bits != nil {
+					if err := checkIntBits(bits); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkResult returns an error if the value v exceeds the limits. It catches results
+// whose size couldn't be predicted before they were computed.
+func checkResult(v interface{}) error {
+	switch t := v.(type) {
+	case *big.Int:
+		if t != nil {
+			return checkIntBits(big.NewInt(int64(t.BitLen())))
+		}
+	case *big.Float:
+		if t != nil && evalLimits.MaxFloatPrec > 0 && int(t.Prec()) > evalLimits.MaxFloatPrec {
+			return ErrLimitExceeded{"Float precision in bits", evalLimits.MaxFloatPrec, "maxfloatprec"}
+		}
+	case BigIntList:
+		return checkListLen(big.NewInt(int64(len(t))))
+	case BigFloatList:
+		return checkListLen(big.NewInt(int64(len(t))))
+	case IPAddrList:
+		return checkListLen(big.NewInt(int64(len(t))))
+	}
+	return nil
+}
@@ -47,6 +47,14 @@ func clone(v interface{}) interface{} {
 		return cloneIntList(t)
 	case BigFloatList:
 		return cloneFloatList(t)
+	case IPAddr:
+		return t.clone()
+	case IPAddrList:
+		l2 := make(IPAddrList, len(t))
+		for i, v := range t {
+			l2[i] = v.clone()
+		}
+		return l2
 	}
 	return v
 }
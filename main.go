@@ -1,11 +1,14 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/chzyer/readline"
@@ -19,55 +22,24 @@ import (
 
 var outputBase numberBase = decimalBase
 
-var completer = readline.NewPrefixCompleter()
-
-func updateAutocomplete() {
-	var items []readline.PrefixCompleterInterface
-
-	for k := range GlobalVars {
-		items = append(items, readline.PcItem(k))
-	}
-
-	for k := range Funcs {
-		items = append(items, readline.PcItem(k))
-	}
-
-	var settings []readline.PrefixCompleterInterface
-	for k := range Settings {
-		settings = append(settings, readline.PcItem(k))
-	}
-	setItem := readline.PcItem("set", settings...)
-	items = append(items, setItem)
-
-	items = append(items, readline.PcItem("def"))
-	items = append(items, readline.PcItem("help"))
-
-	completer.SetChildren(items)
-}
-
+// LoadInitScript changes the settings given in the configuration file, then runs
+// ~/.calcrc, which may change them again.
 func LoadInitScript() (err error) {
-	path := os.ExpandEnv("$HOME/.calcrc")
-
-	file, err := os.Open(path)
-	if err != nil {
-		return
-	}
-	defer file.Close()
+	// The statements run at startup aren't results of the user's input. Turning JSON
+	// output on in them still takes effect.
+	json := jsonOutput
+	jsonOutput = false
+	defer func() { jsonOutput = json || jsonOutput }()
 
-	rdr := bufio.NewReader(file)
-	for {
-		line, err := rdr.ReadString('\n')
-		if err != nil {
-			break
-		}
-		line = strings.TrimSuffix(line, "\n")
-
-		_, err = Parse("init script", []byte(line))
-		if err != nil {
+	if path, err := configPath(); err == nil {
+		if err := LoadConfig(path); err != nil && !os.IsNotExist(err) {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		}
 	}
 
+	path := os.ExpandEnv("$HOME/.calcrc")
+
+	_, err = RunScriptFile(path, os.Stderr, nil, true)
 	return
 }
 
@@ -83,6 +55,11 @@ func printIntList(l BigIntList) {
 }
 
 func printResult(parsed interface{}) {
+	// In JSON mode results are printed as they are evaluated
+	if jsonOutput {
+		return
+	}
+
 	switch t := parsed.(type) {
 	case *big.Int:
 		fmt.Println(outputBase.format(parsed.(fmt.Formatter)))
@@ -96,37 +73,139 @@ func printResult(parsed interface{}) {
 		printIntList(t)
 	case BigFloatList:
 		fmt.Printf("%s\n", parsed)
+	case IPAddr, IPAddrList, NaN:
+		fmt.Printf("%s\n", parsed)
 	case string:
 		fmt.Printf("%s\n", parsed)
+	case Func:
+		fmt.Println(funcSource(t))
 	default:
 		// Don't print the results of statements
 		//fmt.Println(parsed)
 	}
 }
 
+// defineVar defines a variable given as name=value on the command line.
+func defineVar(def string) error {
+	i := strings.IndexByte(def, '=')
+	if i < 0 {
+		return fmt.Errorf("expected name=value")
+	}
+	name, value := strings.TrimSpace(def[:i]), def[i+1:]
+	if !isIdentifier(name) {
+		return fmt.Errorf("%q is not a valid variable name", name)
+	}
+
+	v, err := EvalContext(context.Background(), "--set", []byte(value))
+	if err != nil {
+		return errors.New(errorMessage(firstError(err)))
+	}
+	if noValue(v) {
+		return fmt.Errorf("the value must be an expression")
+	}
+	SetGlobal(name, v)
+	return nil
+}
+
+// Exit statuses
+const (
+	exitOK = iota
+	exitEvalError
+	exitUsage
+)
+
+// exitStatus returns the exit status of a non-interactive run in which the given number
+// of lines failed.
+func exitStatus(failed int) int {
+	if failed > 0 {
+		return exitEvalError
+	}
+	return exitOK
+}
+
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [expression]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [expression...]\n       %s [options] -f script [argument...]\n", os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 	}
-	flag.VarP(&outputBase, "obase", "o", "Output number base. One of decimal, hex, integer. May be partial string.")
+	flag.VarP(&outputBase, "obase", "o", "Output number base. One of dec, hex, bin or poly. May be partial string.")
+	script := flag.StringP("file", "f", "", "Run the script in the named file and exit.")
+	keepGoing := flag.BoolP("keep-going", "k", false, "When running a script or reading from a pipe, continue after a line fails. The exit status is still 1.")
+	flag.Var(&jsonOutput, "json", "Print the result of each input as a JSON object.")
+	flag.Lookup("json").NoOptDefVal = "on"
+	exprs := flag.StringArrayP("expr", "e", nil, "Evaluate the expression. May be repeated; expressions are evaluated in order.")
+	vars := flag.StringArray("set", nil, "Define the variable `name=value` before evaluating anything else. The value may be an expression. May be repeated.")
 	flag.Parse()
 
 	LoadInitScript()
 
-	if flag.NArg() > 0 {
-		parsed, err := Parse("last line", []byte(flag.Arg(0)))
+	for _, v := range *vars {
+		if err := defineVar(v); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --set %s: %v\n", v, err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	// With a script the positional arguments are the script's arguments; otherwise
+	// they are more expressions to evaluate after those given with -e.
+	if *script == "" {
+		*exprs = append(*exprs, flag.Args()...)
+	} else if err := SetScriptArgs(flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	failed := 0
+	for _, e := range *exprs {
+		failed += RunScript("", strings.NewReader(e), os.Stderr, printResult, *keepGoing)
+		if failed > 0 && !*keepGoing {
+			os.Exit(exitStatus(failed))
+		}
+	}
+
+	if *script != "" {
+		n, err := RunScriptFile(*script, os.Stderr, printResult, *keepGoing)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			return
+			os.Exit(exitUsage)
 		}
-		printResult(parsed)
-		return
+		failed += n
 	}
 
+	if *script != "" || len(*exprs) > 0 {
+		os.Exit(exitStatus(failed))
+	}
+
+	// When the input isn't a terminal, evaluate it line by line without prompts or
+	// line editing, printing only the results.
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		failed := RunScript("", os.Stdin, os.Stderr, func(r interface{}) {
+			SetGlobal("last", r)
+			printResult(r)
+		}, *keepGoing)
+		os.Exit(exitStatus(failed))
+	}
+
+	// With autosave on, an interactive session continues where the last one ended
+	if autosave {
+		if err := LoadSession(""); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error: restoring the last session: %v\n", err)
+		}
+	}
+
+	// The input history is kept by calc rather than readline so that repeated lines are
+	// stored once.
+	historyLimit := historySize
+	if historyLimit == 0 {
+		historyLimit = math.MaxInt32
+	}
+	comp := &completer{}
+	hl := &highlighter{}
 	rl, err := readline.NewEx(&readline.Config{
-		Prompt:       "> ",
-		AutoComplete: completer,
+		AutoComplete:           comp,
+		Painter:                hl,
+		HistoryLimit:           historyLimit,
+		DisableAutoSaveHistory: true,
 	})
 
 	if err != nil {
@@ -134,27 +213,100 @@ func main() {
 		return
 	}
 
+	comp.hint = rl.Stderr()
+
+	var hist *inputHistory
+	if path, err := historyPath(); err == nil {
+		if hist, err = loadInputHistory(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: reading the history: %v\n", err)
+		}
+		for _, l := range hist.lines {
+			rl.SaveHistory(l)
+		}
+	}
+
+	// While a line is being evaluated the terminal is not in raw mode, so CTRL-C
+	// raises SIGINT. Use it to abort the evaluation rather than exit.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		for range sigs {
+			interruptEval()
+		}
+	}()
+
+	var joiner lineJoiner
+	n := 0
 	for {
+		// Statements that continue on the next line are read with a different prompt
+		p := "... "
+		if !joiner.incomplete() {
+			p = prompt.expand()
+		}
+		rl.SetPrompt(p)
+		hl.prompt = p
+		takeNameSnapshot()
+		takeColorSnapshot()
+
 		line, err := rl.Readline()
 		if err != nil {
 			if err == readline.ErrInterrupt {
-				break
+				// CTRL-C at the prompt discards the lines being edited
+				joiner.reset()
+				hl.pending = ""
+				continue
 			}
-			if err == io.EOF && !readline.IsTerminal(readline.GetStdin()) {
+			if err == io.EOF {
 				break
 			}
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			continue
 		}
 
-		parsed, err := Parse("last line", []byte(line))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		n++
+		if !joiner.add(n, line) {
+			hl.pending = joiner.pending()
 			continue
-		} else {
+		}
+		hl.pending = ""
+		logical, ok := joiner.take()
+		n = 0
+		if !ok {
+			continue
+		}
+
+		input := strings.TrimSpace(logical.text)
+		rl.SaveHistory(input)
+		if hist != nil {
+			if err := hist.add(input); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: saving the history: %v\n", err)
+				hist = nil
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		setInterruptHandler(cancel)
+		// The preview evaluates on readline's goroutine, so it's kept out until the
+		// variables that the line changes are all set
+		evaluating <- struct{}{}
+		parsed, err := logical.eval(ctx, os.Stderr, "")
+		setInterruptHandler(nil)
+		cancel()
+		recordEntry(input, parsed, err)
+		if err == nil {
 			SetGlobal("last", parsed)
 		}
+		<-evaluating
+		if err != nil {
+			continue
+		}
 
 		printResult(parsed)
 	}
+
+	if autosave {
+		if err := SaveSession(""); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: saving the session: %v\n", err)
+		}
+	}
 }
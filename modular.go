@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// modulusSetting holds the modulus used for integer arithmetic. When it is set, the
+// operators +, -, *, / and ^ operate in the ring of integers modulo m.
+type modulusSetting struct {
+	m *big.Int
+}
+
+var modulus modulusSetting
+
+func (s *modulusSetting) Set(v string) error {
+	if v == "off" || v == "0" {
+		s.m = nil
+		return nil
+	}
+
+	// Allow expressions like 2^61-1. These are evaluated using normal integer
+	// arithmetic, not modulo the previous modulus.
+	old := s.m
+	s.m = nil
+	r, err := Parse("modulus", []byte(v))
+	if err != nil {
+		s.m = old
+		return err
+	}
+
+	m, ok := r.(*big.Int)
+	if !ok || m.Cmp(big.NewInt(1)) <= 0 {
+		s.m = old
+		return fmt.Errorf("modulus must be an integer greater than 1, or 'off'")
+	}
+	s.m = m
+	return nil
+}
+
+func (s modulusSetting) Values() []string {
+	return []string{"off"}
+}
+
+func (s modulusSetting) String() string {
+	if s.m == nil {
+		return "off"
+	}
+	return s.m.String()
+}
+
+func (s modulusSetting) active() bool {
+	return s.m != nil
+}
+
+// modRepresentation selects how results of modular arithmetic are displayed: in the
+// range [0, m) or in the range (-m/2, m/2].
+type modRepresentation int
+
+const (
+	canonicalRep modRepresentation = iota
+	symmetricRep
+)
+
+var modRep modRepresentation = canonicalRep
+
+func (r modRepresentation) String() string {
+	switch r {
+	case canonicalRep:
+		return "canonical"
+	case symmetricRep:
+		return "symmetric"
+	default:
+		return "unknown"
+	}
+}
+
+func (r modRepresentation) Values() []string {
+	return []string{"canonical", "symmetric"}
+}
+
+func (r *modRepresentation) Set(s string) error {
+	switch {
+	case strings.HasPrefix("canonical", s):
+		*r = canonicalRep
+	case strings.HasPrefix("symmetric", s):
+		*r = symmetricRep
+	default:
+		return fmt.Errorf("invalid representation; must be one of canonical or symmetric")
+	}
+	return nil
+}
+
+func isModularOp(op string) bool {
+	switch op {
+	case "+", "-", "*", "/", "^":
+		return true
+	}
+	return false
+}
+
+// evalModularOp evaluates the binary operator op in the ring of integers modulo the
+// current modulus. Lists are operated on element-wise, with each other or with an
+// integer.
+func evalModularOp(op string, a, b interface{}) (interface{}, error) {
+	switch at := a.(type) {
+	case *big.Int:
+		switch bt := b.(type) {
+		case *big.Int:
+			return modBinaryOp(op, at, bt)
+		case BigIntList:
+			return modListOp(op, len(bt), func(i int) (*big.Int, *big.Int) { return at, bt[i] })
+		}
+	case BigIntList:
+		switch bt := b.(type) {
+		case *big.Int:
+			return modListOp(op, len(at), func(i int) (*big.Int, *big.Int) { return at[i], bt })
+		case BigIntList:
+			if len(at) != len(bt) {
+				return nil, fmt.Errorf("lists are different lengths")
+			}
+			return modListOp(op, len(at), func(i int) (*big.Int, *big.Int) { return at[i], bt[i] })
+		}
+	}
+
+	return nil, fmt.Errorf("modular arithmetic is only defined for integers and integer lists (modulus is %v)", modulus)
+}
+
+// modListOp returns the list of n results of op on the pairs of operands returned by
+// operands.
+func modListOp(op string, n int, operands func(i int) (a, b *big.Int)) (interface{}, error) {
+	l := make(BigIntList, n)
+	for i := range l {
+		a, b := operands(i)
+		r, err := modBinaryOp(op, a, b)
+		if err != nil {
+			return nil, err
+		}
+		l[i] = r
+	}
+	return l, nil
+}
+
+func modBinaryOp(op string, a, b *big.Int) (*big.Int, error) {
+	m := modulus.m
+	r := big.NewInt(0)
+	ar := big.NewInt(0).Mod(a, m)
+
+	switch op {
+	case "+":
+		r.Add(ar, b)
+	case "-":
+		r.Sub(ar, b)
+	case "*":
+		r.Mul(ar, b)
+	case "/":
+		inv, err := modInverse(b, m)
+		if err != nil {
+			return nil, err
+		}
+		r.Mul(ar, inv)
+	case "^":
+		e := b
+		if e.Sign() < 0 {
+			inv, err := modInverse(ar, m)
+			if err != nil {
+				return nil, err
+			}
+			ar = inv
+			e = big.NewInt(0).Neg(e)
+		}
+		r.Exp(ar, e, m)
+	default:
+		return nil, fmt.Errorf("Unsupported modular operation %v", op)
+	}
+
+	return modReduce(r), nil
+}
+
+func modInverse(a, m *big.Int) (*big.Int, error) {
+	inv := big.NewInt(0).ModInverse(big.NewInt(0).Mod(a, m), m)
+	if inv == nil {
+		return nil, fmt.Errorf("%v has no inverse modulo %v", a, m)
+	}
+	return inv, nil
+}
+
+// modReduce reduces n modulo the current modulus, in the configured representation.
+func modReduce(n *big.Int) *big.Int {
+	m := modulus.m
+	r := big.NewInt(0).Mod(n, m)
+	if modRep == symmetricRep {
+		h := big.NewInt(0).Rsh(m, 1)
+		if r.Cmp(h) > 0 {
+			r.Sub(r, m)
+		}
+	}
+	return r
+}
+
+// modValue returns v with its integers reduced modulo the current modulus, or v itself
+// when there is no modulus or v isn't an integer or integer list. It reduces the
+// operands and results of the operators that aren't ring operations, such as
+// comparisons, so that they operate on the elements of the ring.
+func modValue(v interface{}) interface{} {
+	if !modulus.active() {
+		return v
+	}
+	switch t := v.(type) {
+	case *big.Int:
+		if t == nil {
+			// The value of an operation that failed
+			return v
+		}
+		return modReduce(t)
+	case BigIntList:
+		l := make(BigIntList, len(t))
+		for i, n := range t {
+			l[i] = modReduce(n)
+		}
+		return l
+	}
+	return v
+}
+
+func modNeg(a interface{}) (interface{}, error) {
+	switch t := a.(type) {
+	case *big.Int:
+		return modReduce(big.NewInt(0).Neg(t)), nil
+	case BigIntList:
+		l := make(BigIntList, len(t))
+		for i, v := range t {
+			l[i] = modReduce(big.NewInt(0).Neg(v))
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("modular arithmetic is only defined for integers and integer lists (modulus is %v)", modulus)
+}
+
+func modPowFn(a, e, m *big.Int) (*big.Int, error) {
+	if m.Cmp(big.NewInt(1)) <= 0 {
+		return nil, fmt.Errorf("modulus must be greater than 1")
+	}
+	if e.Sign() < 0 {
+		inv, err := modInverse(a, m)
+		if err != nil {
+			return nil, err
+		}
+		return big.NewInt(0).Exp(inv, big.NewInt(0).Neg(e), m), nil
+	}
+	return big.NewInt(0).Exp(a, e, m), nil
+}
+
+func modInverseFn(a, m *big.Int) (*big.Int, error) {
+	if m.Cmp(big.NewInt(1)) <= 0 {
+		return nil, fmt.Errorf("modulus must be greater than 1")
+	}
+	return modInverse(a, m)
+}
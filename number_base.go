@@ -2,16 +2,18 @@ package main
 
 import (
 	"fmt"
+	"math/big"
 	"strings"
 )
 
-// Hex, decimal, binary
+// Hex, decimal, binary, or polynomial over GF(2)
 type numberBase int
 
 const (
 	hexBase numberBase = iota
 	decimalBase
 	binaryBase
+	polyBase
 )
 
 func (n numberBase) String() string {
@@ -22,6 +24,8 @@ func (n numberBase) String() string {
 		return "dec"
 	case binaryBase:
 		return "bin"
+	case polyBase:
+		return "poly"
 	default:
 		return "unknown"
 	}
@@ -35,12 +39,18 @@ func (n *numberBase) Set(s string) error {
 		*n = decimalBase
 	case strings.Contains("bin", s):
 		*n = binaryBase
+	case strings.Contains("poly", s):
+		*n = polyBase
 	default:
 		return fmt.Errorf("invalid base")
 	}
 	return nil
 }
 
+func (n numberBase) Values() []string {
+	return []string{"hex", "dec", "bin", "poly"}
+}
+
 func (n numberBase) Type() string {
 	return "numberBase"
 }
@@ -53,6 +63,11 @@ func (n numberBase) format(num fmt.Formatter) string {
 		return fmt.Sprintf("%v", num)
 	case binaryBase:
 		return fmt.Sprintf("0b%b", num)
+	case polyBase:
+		if i, ok := num.(*big.Int); ok {
+			return formatPoly(i)
+		}
+		return fmt.Sprintf("%v", num)
 	default:
 		return fmt.Sprintf("%v", num)
 	}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+)
+
+// Polynomials over GF(2) are represented as integers, where bit i is the coefficient
+// of x^i. For example 0x11b is x^8 + x^4 + x^3 + x + 1.
+
+func checkPoly(polys ...*big.Int) error {
+	for _, p := range polys {
+		if p.Sign() < 0 {
+			return fmt.Errorf("polynomials must be represented by non-negative integers")
+		}
+	}
+	return nil
+}
+
+// clmul returns the carry-less product of a and b. It takes time quadratic in the size
+// of the operands, so each pass counts as a step of the evaluation.
+func clmul(a, b *big.Int) (*big.Int, error) {
+	if err := checkPoly(a, b); err != nil {
+		return nil, err
+	}
+	if a.Sign() == 0 || b.Sign() == 0 {
+		return big.NewInt(0), nil
+	}
+	if err := checkIntBits(big.NewInt(int64(a.BitLen() + b.BitLen() - 1))); err != nil {
+		return nil, err
+	}
+
+	r := big.NewInt(0)
+	t := big.NewInt(0)
+	for i := 0; i < b.BitLen(); i++ {
+		if err := step(); err != nil {
+			return nil, err
+		}
+		if b.Bit(i) == 1 {
+			r.Xor(r, t.Lsh(a, uint(i)))
+		}
+	}
+	return r, nil
+}
+
+// polyDivMod divides a by m, returning the quotient and remainder.
+func polyDivMod(a, m *big.Int) (q, r *big.Int, err error) {
+	if err = checkPoly(a, m); err != nil {
+		return
+	}
+	if m.Sign() == 0 {
+		return nil, nil, fmt.Errorf("polynomial division by zero")
+	}
+
+	q = big.NewInt(0)
+	r = cloneInt(a)
+	t := big.NewInt(0)
+	mdeg := m.BitLen() - 1
+	for r.BitLen()-1 >= mdeg {
+		if err = step(); err != nil {
+			return nil, nil, err
+		}
+		shift := r.BitLen() - 1 - mdeg
+		q.SetBit(q, shift, 1)
+		r.Xor(r, t.Lsh(m, uint(shift)))
+	}
+	return
+}
+
+func pmod(a, m *big.Int) (*big.Int, error) {
+	_, r, err := polyDivMod(a, m)
+	return r, err
+}
+
+func pdiv(a, m *big.Int) (*big.Int, error) {
+	q, _, err := polyDivMod(a, m)
+	return q, err
+}
+
+func pgcd(a, b *big.Int) (*big.Int, error) {
+	if err := checkPoly(a, b); err != nil {
+		return nil, err
+	}
+
+	a, b = cloneInt(a), cloneInt(b)
+	for b.Sign() != 0 {
+		r, err := pmod(a, b)
+		if err != nil {
+			return nil, err
+		}
+		a, b = b, r
+	}
+	return a, nil
+}
+
+// gfmul multiplies a and b in the field GF(2^n) defined by the reduction polynomial
+// poly of degree n.
+func gfmul(a, b, poly *big.Int) (*big.Int, error) {
+	p, err := clmul(a, b)
+	if err != nil {
+		return nil, err
+	}
+	return pmod(p, poly)
+}
+
+// gfinv returns the multiplicative inverse of a in the field GF(2^n) defined by the
+// reduction polynomial poly, using the extended Euclidean algorithm.
+func gfinv(a, poly *big.Int) (*big.Int, error) {
+	r0, err := pmod(a, poly)
+	if err != nil {
+		return nil, err
+	}
+	if r0.Sign() == 0 {
+		return nil, fmt.Errorf("0 has no inverse")
+	}
+
+	r1 := cloneInt(poly)
+	s0, s1 := big.NewInt(1), big.NewInt(0)
+	for r1.Sign() != 0 {
+		q, r, err := polyDivMod(r0, r1)
+		if err != nil {
+			return nil, err
+		}
+		qs, err := clmul(q, s1)
+		if err != nil {
+			return nil, err
+		}
+		r0, r1 = r1, r
+		s0, s1 = s1, qs.Xor(qs, s0)
+	}
+
+	if r0.Cmp(big.NewInt(1)) != 0 {
+		return nil, fmt.Errorf("no inverse exists; the reduction polynomial is not irreducible")
+	}
+	return pmod(s0, poly)
+}
+
+// formatPoly formats the integer n as a polynomial over GF(2), for example x^3 + x + 1.
+func formatPoly(n *big.Int) string {
+	if n.Sign() == 0 {
+		return "0"
+	}
+	if n.Sign() < 0 {
+		return fmt.Sprintf("%v", n)
+	}
+
+	var buf bytes.Buffer
+	for i := n.BitLen() - 1; i >= 0; i-- {
+		if n.Bit(i) == 0 {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString(" + ")
+		}
+		switch i {
+		case 0:
+			buf.WriteString("1")
+		case 1:
+			buf.WriteString("x")
+		default:
+			fmt.Fprintf(&buf, "x^%d", i)
+		}
+	}
+	return buf.String()
+}
+
+func registerPoly() {
+	RegisterBuiltin("clmul", clmul, "return the carry-less product of p1 and p2, treating them as polynomials over GF(2)")
+	RegisterBuiltin("pmod", pmod, "return the remainder of the GF(2) polynomial p1 divided by p2")
+	RegisterBuiltin("pdiv", pdiv, "return the quotient of the GF(2) polynomial p1 divided by p2")
+	RegisterBuiltin("pgcd", pgcd, "return the greatest common divisor of the GF(2) polynomials p1 and p2")
+	RegisterBuiltin("gfmul", gfmul, "multiply p1 and p2 in GF(2^n) with the reduction polynomial p3 of degree n (0x11b for AES)")
+	RegisterBuiltin("gfinv", gfinv, "return the multiplicative inverse of p1 in GF(2^n) with the reduction polynomial p2 of degree n")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// preview selects showing the result of the line being typed below it, before ENTER is
+// pressed. It is off by default.
+var preview boolSetting
+
+// previewTimeout limits how long the preview may evaluate a line, so that typing doesn't
+// stall on an expensive expression.
+const previewTimeout = 100 * time.Millisecond
+
+// previewLimits bound the size of the values that the preview computes. The timeout is
+// only checked between operations, so a single operation on huge numbers, such as
+// 3^(2^26), would stall typing until it finished.
+var previewLimits = Limits{
+	MaxIntBits:   1 << 16,
+	MaxFloatPrec: 1 << 16,
+	MaxListLen:   1 << 20,
+}
+
+// evaluating is held while a line is evaluated. Readline repaints the line from its own
+// goroutine, for example when the terminal is resized, and the preview is skipped rather
+// than evaluate at the same time.
+var evaluating = make(chan struct{}, 1)
+
+// previewLine evaluates text, the statement being typed, for the preview. It returns the
+// text to show and whether it is an error, or "" if there is nothing to show. Only an
+// expression is evaluated: a statement such as an assignment, def or set doesn't match
+// the Preview rule and so isn't run, and neither is input that is still incomplete.
+func previewLine(text string) (string, bool) {
+	if strings.TrimSpace(text) == "" {
+		return "", false
+	}
+
+	select {
+	case evaluating <- struct{}{}:
+		defer func() { <-evaluating }()
+	default:
+		return "", false
+	}
+
+	// Traced calls are written when the line is entered, not as it's typed
+	defer func(w io.Writer) { traceWriter = w }(traceWriter)
+	traceWriter = ioutil.Discard
+
+	ctx, cancel := context.WithTimeout(WithLimits(context.Background(), previewContextLimits()), previewTimeout)
+	defer cancel()
+	r, err := EvalContext(ctx, "preview", []byte(text), Entrypoint("Preview"))
+	pv, matched := r.(previewValue)
+	var le ErrLimitExceeded
+	switch {
+	case err == ErrInterrupted:
+		return fmt.Sprintf("takes longer than %v to preview", previewTimeout), true
+	case !matched:
+		return "", false
+	case err != nil && errors.As(rootError(firstError(err)), &le):
+		return "too large to preview", true
+	case err != nil:
+		return "Error: " + errorMessage(firstError(err)), true
+	case noValue(pv.v):
+		return "", false
+	}
+	return "= " + displayValue(pv.v), false
+}
+
+// previewContextLimits returns the limits of the session lowered to previewLimits.
+func previewContextLimits() Limits {
+	l := CurrentLimits()
+	lower := func(v *int, max int) {
+		if *v == 0 || *v > max {
+			*v = max
+		}
+	}
+	lower(&l.MaxIntBits, previewLimits.MaxIntBits)
+	lower(&l.MaxFloatPrec, previewLimits.MaxFloatPrec)
+	lower(&l.MaxListLen, previewLimits.MaxListLen)
+	return l
+}
+
+// previewValue is the value of the Preview rule.
+type previewValue struct {
+	v interface{}
+}
+
+// previewHint returns the escape sequences that show text on the line below the cursor
+// and then return the cursor to where it was. The line below is made to exist first, by
+// moving down and back up, so that saving and restoring the cursor isn't upset by the
+// screen scrolling. Text is cut to fit in width columns.
+func previewHint(text string, isErr bool, width int) string {
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i] + " ..."
+	}
+	if r := []rune(text); width > 1 && len(r) > width-1 {
+		text = string(r[:width-1])
+	}
+
+	class := "preview"
+	if isErr {
+		class = "previewerror"
+	}
+	if sgr := currentColors()[class]; colorOutput && sgr != "" {
+		text = fmt.Sprintf("\x1b[%sm%s\x1b[0m", sgr, text)
+	}
+	return "\x1bD\x1bM\x1b7\x1bD\r" + text + "\x1b[K\x1b8"
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// promptTemplate is the prompt of the interactive calculator. Fields in braces, such as
+// {obase}, are replaced by the current value of what they name; {{ and }} are literal
+// braces.
+type promptTemplate string
+
+var prompt promptTemplate = "{modes}> "
+
+// promptFields are the fields that a prompt may contain, and their values.
+var promptFields = map[string]func() string{
+	// The base that integers are printed in
+	"obase": func() string { return outputBase.String() },
+	// The number of the next input, for _N and out(N)
+	"n":       func() string { return strconv.Itoa(len(entries) + 1) },
+	"modulus": func() string { return modulus.String() },
+	"modrep":  func() string { return modRep.String() },
+	"ieee":    func() string { return ieee.String() },
+	// The modes that change results, in brackets, or nothing if none are on
+	"modes": activeModes,
+}
+
+// activeModes returns the modes that change the results of arithmetic, such as
+// "[mod 97] ", or "" if none are on.
+func activeModes() string {
+	var modes []string
+	if modulus.active() {
+		m := "mod " + modulus.String()
+		if modRep == symmetricRep {
+			m += " sym"
+		}
+		modes = append(modes, m)
+	}
+	if ieee {
+		modes = append(modes, "ieee")
+	}
+	if len(modes) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(modes, ", ") + "] "
+}
+
+func (p *promptTemplate) Set(s string) error {
+	if _, err := expandPrompt(s); err != nil {
+		return err
+	}
+	*p = promptTemplate(s)
+	return nil
+}
+
+func (p promptTemplate) String() string {
+	return string(p)
+}
+
+// expand returns the prompt with its fields replaced by their values.
+func (p promptTemplate) expand() string {
+	s, err := expandPrompt(string(p))
+	if err != nil {
+		return "> "
+	}
+	return s
+}
+
+func expandPrompt(tmpl string) (string, error) {
+	var buf strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		switch c := tmpl[i]; {
+		case (c == '{' || c == '}') && i+1 < len(tmpl) && tmpl[i+1] == c:
+			buf.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(tmpl[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed { in prompt")
+			}
+			name := tmpl[i+1 : i+end]
+			field, ok := promptFields[name]
+			if !ok {
+				return "", fmt.Errorf("unknown prompt field {%s}; must be one of %s", name, strings.Join(promptFieldNames(), ", "))
+			}
+			buf.WriteString(field())
+			i += end
+		case c == '}':
+			return "", fmt.Errorf("unmatched } in prompt; write }} for a brace")
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String(), nil
+}
+
+func promptFieldNames() []string {
+	var names []string
+	for k := range promptFields {
+		names = append(names, "{"+k+"}")
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Input may contain comments, which start with # or // and extend to the end of the
+// line. A statement may span several lines: a line that ends with a backslash, or that
+// leaves a bracket open, continues on the next line. The lines of a statement are joined
+// into a single logical line before they are parsed.
+
+// segment records which physical line a part of a logical line came from.
+type segment struct {
+	offset int    // offset of the segment in the logical line
+	line   int    // line number of the physical line
+	text   string // the physical line
+}
+
+type logicalLine struct {
+	text string
+	segs []segment
+}
+
+// lineJoiner joins physical lines into logical lines.
+type lineJoiner struct {
+	buf   strings.Builder
+	segs  []segment
+	depth int
+	cont  bool
+}
+
+// add appends the physical line, which is line number n of the input. It returns true
+// if the logical line is complete.
+func (j *lineJoiner) add(n int, line string) bool {
+	code, depth := scanLine(line)
+	j.depth += depth
+
+	code = strings.TrimRight(code, " \t\r")
+	j.cont = strings.HasSuffix(code, "\\")
+	if j.cont {
+		code = code[:len(code)-1]
+	}
+
+	if j.buf.Len() > 0 {
+		j.buf.WriteByte(' ')
+	}
+	j.segs = append(j.segs, segment{offset: j.buf.Len(), line: n, text: line})
+	j.buf.WriteString(code)
+
+	return !j.incomplete()
+}
+
+// incomplete returns true if the logical line being joined continues on the next line.
+func (j *lineJoiner) incomplete() bool {
+	return j.cont || j.depth > 0
+}
+
+// pending returns the text of the lines of the logical line that have been added.
+func (j *lineJoiner) pending() string {
+	return j.buf.String()
+}
+
+// take returns the logical line that has been joined and resets the joiner. It returns
+// false if the line is blank.
+func (j *lineJoiner) take() (logicalLine, bool) {
+	l := logicalLine{text: j.buf.String(), segs: j.segs}
+	j.reset()
+	return l, strings.TrimSpace(l.text) != ""
+}
+
+func (j *lineJoiner) reset() {
+	j.buf.Reset()
+	j.segs = nil
+	j.depth = 0
+	j.cont = false
+}
+
+// scanLine returns the code in line with any comment removed, and the change in the
+// depth of brackets. Comment characters in strings don't begin comments.
+func scanLine(line string) (code string, depth int) {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if inString && c == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+			// An escaped quote or backslash doesn't end the string
+			i++
+			continue
+		}
+		if c == '"' {
+			inString = !inString
+		}
+		if inString {
+			continue
+		}
+		switch {
+		case c == '#', c == '/' && i+1 < len(line) && line[i+1] == '/':
+			return line[:i], depth
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		}
+	}
+	return line, depth
+}
+
+// source returns the physical lines that the logical line was joined from.
+func (l logicalLine) source() string {
+	lines := make([]string, len(l.segs))
+	for i, s := range l.segs {
+		lines[i] = s.text
+	}
+	return strings.Join(lines, "\n")
+}
+
+// locator returns a locator that maps positions in the logical line to the physical
+// lines of the source called name.
+func (l logicalLine) locator(name string) locator {
+	return func(pos position) (loc sourceLocation, ok bool) {
+		if len(l.segs) == 0 || pos.line != 1 {
+			return
+		}
+		seg := l.segs[0]
+		for _, s := range l.segs {
+			if s.offset <= pos.offset {
+				seg = s
+			}
+		}
+
+		n := pos.offset - seg.offset
+		if n > len(seg.text) {
+			n = len(seg.text)
+		}
+		col := utf8.RuneCountInString(seg.text[:n]) + 1
+
+		return sourceLocation{name: name, line: seg.line, col: col, text: seg.text}, true
+	}
+}
+
+// eval evaluates the logical line, reporting errors to w as coming from the source name.
+// In JSON mode the result or error is written as a JSON object instead.
+func (l logicalLine) eval(ctx context.Context, w io.Writer, name string) (interface{}, error) {
+	parsed, err := EvalContext(ctx, name, []byte(l.text))
+	if jsonOutput {
+		writeJSONResult(jsonWriter, l, name, parsed, err)
+		return parsed, err
+	}
+	if err != nil {
+		reportErrorAt(w, err, l.locator(name))
+	}
+	return parsed, err
+}
+
+// RunScript evaluates the statements and expressions read from r, which is called name
+// in error messages. The result of each line is passed to result if it isn't nil. Errors
+// are reported to w. Unless keepGoing is true the script stops at the first line that
+// fails. The number of lines that failed is returned.
+func RunScript(name string, r io.Reader, w io.Writer, result func(interface{}), keepGoing bool) (failed int) {
+	var j lineJoiner
+
+	run := func() {
+		l, ok := j.take()
+		if !ok {
+			return
+		}
+		parsed, err := l.eval(context.Background(), w, name)
+		if err != nil {
+			failed++
+			return
+		}
+		if result != nil {
+			result(parsed)
+		}
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16*1024*1024)
+	n := 0
+	for sc.Scan() {
+		n++
+		if j.add(n, sc.Text()) {
+			run()
+			if failed > 0 && !keepGoing {
+				return
+			}
+		}
+	}
+	// An unterminated statement at the end of the file is still evaluated so that the
+	// error is reported.
+	run()
+
+	if err := sc.Err(); err != nil {
+		fmt.Fprintf(w, "Error: reading %s: %v\n", name, err)
+		failed++
+	}
+	return
+}
+
+// parseNumber parses s as an integer or a float, written the same way as numbers in
+// expressions, optionally with a leading sign.
+func parseNumber(s string) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	digits := strings.TrimLeft(s, "+-")
+	if digits != "" && !strings.ContainsAny(digits, "+-") {
+		if i, ok := big.NewInt(0).SetString(s, 0); ok {
+			return i, nil
+		}
+		if f, ok := big.NewFloat(0).SetPrec(64).SetString(s); ok {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not a number", s)
+}
+
+// SetScriptArgs makes the arguments of a script available to it as the variables $1,
+// $2 and so on, argc, the number of arguments, and argv, the list of arguments. The
+// arguments must be numbers. If any of them is a float, argv is a list of floats.
+func SetScriptArgs(args []string) error {
+	vals := make([]interface{}, len(args))
+	ints := make(BigIntList, 0, len(args))
+	for i, a := range args {
+		n, err := parseNumber(a)
+		if err != nil {
+			return fmt.Errorf("script argument %d: %v", i+1, err)
+		}
+		vals[i] = n
+		if ni, ok := n.(*big.Int); ok {
+			ints = append(ints, ni)
+		}
+	}
+
+	var argv interface{} = ints
+	if len(ints) < len(vals) {
+		floats := make(BigFloatList, len(vals))
+		for i, v := range vals {
+			if ni, ok := v.(*big.Int); ok {
+				floats[i] = big.NewFloat(0).SetInt(ni)
+			} else {
+				floats[i] = v.(*big.Float)
+			}
+		}
+		argv = floats
+	}
+
+	for i, v := range vals {
+		SetGlobal(fmt.Sprintf("$%d", i+1), v)
+	}
+	SetGlobal("argc", big.NewInt(int64(len(args))))
+	SetGlobal("argv", argv)
+	return nil
+}
+
+// RunScriptFile evaluates the script in the file at path.
+func RunScriptFile(path string, w io.Writer, result func(interface{}), keepGoing bool) (failed int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return RunScript(path, file, w, result, keepGoing), nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A session is saved as a script that recreates the user-defined functions, the
+// variables and the settings when it is run.
+
+// autosave selects saving the session when the interactive calculator exits, and
+// restoring it when it starts.
+var autosave boolSetting
+
+// sessionPath returns the path of the file that sessions are saved to by default.
+func sessionPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "calc", "session.calc"), nil
+}
+
+// SaveSession writes the session to the file at path, or to the default session file if
+// path is empty.
+func SaveSession(path string) (err error) {
+	if path == "" {
+		if path, err = sessionPath(); err != nil {
+			return
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return
+	}
+
+	w := bufio.NewWriter(file)
+	writeSession(w)
+	if err = w.Flush(); err != nil {
+		file.Close()
+		return
+	}
+	return file.Close()
+}
+
+// LoadSession runs the session script at path, or the default session file if path is
+// empty.
+func LoadSession(path string) (err error) {
+	if path == "" {
+		if path, err = sessionPath(); err != nil {
+			return
+		}
+	}
+
+	// The statements of the session aren't results of the input that loaded it
+	json := jsonOutput
+	jsonOutput = false
+	defer func() { jsonOutput = json }()
+
+	failed, err := RunScriptFile(path, os.Stderr, nil, true)
+	if err != nil {
+		return
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d lines of %s failed", failed, path)
+	}
+	return nil
+}
+
+// loadSession is set to LoadSession when the package is initialized. The load statement
+// calls it rather than LoadSession, which would make an initialization cycle since
+// loading evaluates statements.
+var loadSession func(path string) error
+
+func init() {
+	loadSession = LoadSession
+}
+
+func writeSession(w io.Writer) {
+	fmt.Fprintf(w, "# calc session\n")
+
+	// Functions are written in the order they were defined, since a function must be
+	// defined before functions that call it.
+	for _, f := range userFuncs() {
+		fmt.Fprintln(w, funcSource(f))
+	}
+
+	for _, k := range userVarNames() {
+		// last and the script arguments belong to a single run
+		if k == "last" || k == "argc" || k == "argv" {
+			continue
+		}
+		lit, ok := literal(GlobalVars[k])
+		if !ok {
+			fmt.Fprintf(w, "# %s: can't be saved, since a %s can't be written as an expression\n", k, typeName(GlobalVars[k]))
+			continue
+		}
+		fmt.Fprintf(w, "%s = %s\n", k, lit)
+	}
+
+	// Settings are written last so that the modulus doesn't change the values above as
+	// they are read.
+	var names []string
+	for k := range Settings {
+		// The output format belongs to the program that requested it, and whether to
+		// use colour to the terminal
+		if k != "json" && k != "color" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(w, "set %s %s\n", k, settingLiteral(Settings[k].String()))
+	}
+}
+
+func helpLiteral(help string) string {
+	if help == "" {
+		return ""
+	}
+	return fmt.Sprintf(" \"%s\"", help)
+}
+
+// literal returns an expression that evaluates to the value v, if there is one.
+func literal(v interface{}) (string, bool) {
+	switch t := v.(type) {
+	case *big.Int:
+		return t.String(), true
+	case *big.Float:
+		return floatLiteral(t), true
+	case BigIntList:
+		l := make([]string, len(t))
+		for i, n := range t {
+			l[i] = n.String()
+		}
+		return "[" + strings.Join(l, ", ") + "]", true
+	case BigFloatList:
+		l := make([]string, len(t))
+		for i, f := range t {
+			l[i] = floatLiteral(f)
+		}
+		return "[" + strings.Join(l, ", ") + "]", true
+	case IPAddr:
+		return t.String(), true
+	case IPAddrList:
+		return t.String(), true
+	case string:
+		return "\"" + stringEscaper.Replace(t) + "\"", true
+	case *DefinedFunc:
+		if f, ok := Funcs[t.name]; ok && f == Func(t) {
+			return t.name, true
+		}
+		// The variables bound by a closure can't be recreated
+		if len(t.bound) > 0 || strings.Contains(string(t.body), "}") {
+			return "", false
+		}
+		return funcSource(t), true
+	case *BuiltinFunc:
+		return t.name, true
+	}
+	return "", false
+}
+
+// stringEscaper and stringUnescaper convert between a string and its text in a string
+// literal.
+var (
+	stringEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	stringUnescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n", `\t`, "\t")
+)
+
+// unescapeString returns the string written as s between the quotes of a string literal.
+func unescapeString(s string) string {
+	return stringUnescaper.Replace(s)
+}
+
+// floatLiteral formats f so that it is read back as the same float: in decimal notation
+// with a decimal point.
+func floatLiteral(f *big.Float) string {
+	var buf bytes.Buffer
+	if f.Sign() < 0 {
+		buf.WriteString("-")
+	}
+	s := big.NewFloat(0).Abs(f).Text('f', -1)
+	buf.WriteString(s)
+	if !strings.Contains(s, ".") {
+		buf.WriteString(".0")
+	}
+	return buf.String()
+}
@@ -1,21 +1,124 @@
 package main
 
-import "fmt"
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
 
+// A Setting is a value that changes how calc behaves. It is changed with the set
+// statement and displayed with its String method.
 type Setting interface {
 	Set(s string) error
+	String() string
 }
 
-var Settings = map[string]Setting{}
+// A settingValuer is a Setting that can list the values it accepts, for completion.
+// Settings that accept numbers list only their special values, such as off.
+type settingValuer interface {
+	Values() []string
+}
+
+// settingInfo describes a registered Setting.
+type settingInfo struct {
+	Setting
+	name string
+	// typ is the kind of value, which describes the values accepted if the Setting
+	// can't list them.
+	typ  string
+	help string
+	// def is the default value, which is the value when the setting is registered.
+	def string
+}
+
+// settingTypes describes the values accepted by each type of setting that doesn't list
+// them itself.
+var settingTypes = map[string]string{
+	"limit":    "a positive integer, or off",
+	"duration": "a duration such as 500ms, 10s or 2m, or off",
+	"modulus":  "an integer greater than 1, which may be an expression, or off",
+	"colors":   "class=color pairs separated by commas",
+	"string":   "any text, in double quotes if it has spaces",
+	"prompt":   "text in double quotes, in which {n}, {obase}, {modulus}, {modrep}, {ieee} and {modes} are replaced by their values",
+}
+
+// Allowed returns a description of the values that the setting accepts.
+func (s *settingInfo) Allowed() string {
+	if v, ok := s.Setting.(settingValuer); ok && settingTypes[s.typ] == "" {
+		return strings.Join(v.Values(), ", ")
+	}
+	return settingTypes[s.typ]
+}
+
+var Settings = map[string]*settingInfo{}
+
+// registerSetting adds the setting s called name. Its current value is its default.
+func registerSetting(name, typ, help string, s Setting) {
+	Settings[name] = &settingInfo{Setting: s, name: name, typ: typ, help: help, def: s.String()}
+}
+
+// boolSetting is a Setting that is either on or off.
+type boolSetting bool
+
+func (b *boolSetting) Set(s string) error {
+	switch s {
+	case "on", "true", "1":
+		*b = true
+	case "off", "false", "0":
+		*b = false
+	default:
+		return fmt.Errorf("invalid value; must be one of on or off")
+	}
+	return nil
+}
+
+func (b boolSetting) String() string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func (b boolSetting) Values() []string {
+	return []string{"on", "off"}
+}
+
+func (b boolSetting) Type() string {
+	return "bool"
+}
 
 func init() {
-	Settings["obase"] = &outputBase
+	registerSetting("obase", "base", "the base that integers are printed in", &outputBase)
+	registerSetting("ieee", "bool", "make infinities and NaN values rather than errors", &ieee)
+	registerSetting("modulus", "modulus", "do integer arithmetic modulo this number", &modulus)
+	registerSetting("modrep", "enum", "print results modulo m in [0, m) or (-m/2, m/2]", &modRep)
+	registerSetting("timeout", "duration", "stop evaluating a line after this long", &timeout)
+	registerSetting("json", "bool", "print the result of each input as a JSON object", &jsonOutput)
+	registerSetting("autosave", "bool", "save the session on exit and restore it on start", &autosave)
+	registerSetting("color", "bool", "colour the input as it's typed", &colorOutput)
+	registerSetting("colors", "colors", "the colours used for the input", colors)
+	registerSetting("prompt", "prompt", "the prompt of the interactive calculator", &prompt)
+	registerSetting("preview", "bool", "show the result of the line being typed below it", &preview)
+	registerSetting("histsize", "limit", "the number of input lines kept in the history", limitSetting{&historySize})
+	registerSetting("maxintbits", "limit", "the largest size of an integer in bits", limitSetting{&limits.MaxIntBits})
+	registerSetting("maxfloatprec", "limit", "the largest precision of a float in bits", limitSetting{&limits.MaxFloatPrec})
+	registerSetting("maxlistlen", "limit", "the largest number of elements in a list", limitSetting{&limits.MaxListLen})
+	registerSetting("maxdepth", "limit", "the deepest that function calls may nest", limitSetting{&limits.MaxDepth})
+	registerSetting("maxsteps", "limit", "the most operations that evaluating a line may take", limitSetting{&limits.MaxSteps})
 }
 
+// SetSetting changes the setting called name to value. The value "default" restores
+// its default.
 func SetSetting(name, value string) error {
 	s, ok := Settings[name]
 	if !ok {
-		return fmt.Errorf("No sucvh setting %s", name)
+		return ErrNoSuchSetting{name}
+	}
+	if value == "default" {
+		value = s.def
 	}
 
 	err := s.Set(value)
@@ -23,11 +126,103 @@ func SetSetting(name, value string) error {
 		return err
 	}
 
-	updateAutocomplete()
 	return nil
 }
 
+type ErrNoSuchSetting struct {
+	Name string
+}
+
+func (e ErrNoSuchSetting) Error() string {
+	return fmt.Sprintf("No such setting %s", e.Name)
+}
+
+func (e ErrNoSuchSetting) Suggestions() []string {
+	names := make([]string, 0, len(Settings))
+	for k := range Settings {
+		names = append(names, k)
+	}
+	return suggestNames(e.Name, names)
+}
+
 func SettingExists(name string) (ok bool) {
 	_, ok = Settings[name]
 	return
 }
+
+// printSettings prints every setting with its value, its help and the values it
+// accepts. The default is shown for settings that have been changed.
+func printSettings() {
+	var buf bytes.Buffer
+	for _, k := range settingNames() {
+		s := Settings[k]
+		v := settingLiteral(s.String())
+		if d := settingLiteral(s.def); d != v {
+			v = fmt.Sprintf("%s (default %s)", v, d)
+		}
+		fmt.Fprintf(&buf, "%s = %s\n    %s\n    values: %s\n", k, v, s.help, s.Allowed())
+	}
+	page(buf.String())
+}
+
+// settingLiteral returns v as it is written in a set statement, in quotes if it is empty
+// or would otherwise be cut short or read differently. Quoted values are escaped like
+// strings.
+func settingLiteral(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\n;#\"\\") || strings.HasPrefix(v, "//") {
+		return `"` + stringEscaper.Replace(v) + `"`
+	}
+	return v
+}
+
+// configPath returns the path of the configuration file, which is calc/config in the
+// user's configuration directory ($XDG_CONFIG_HOME or ~/.config on Linux).
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "calc", "config"), nil
+}
+
+// LoadConfig changes the settings given in the configuration file at path. Each line is
+// a setting's name and its value, optionally separated by =, as in
+//
+//	obase = hex
+//
+// Blank lines and comments, which start with #, are ignored. The settings on lines that
+// are valid are changed even if other lines aren't; the errors are returned together.
+func LoadConfig(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var errs []string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value := line, ""
+		if i := strings.IndexAny(line, "= \t"); i >= 0 {
+			name, value = line[:i], strings.TrimSpace(line[i:])
+			value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+		}
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = unescapeString(value[1 : len(value)-1])
+		}
+		if err := SetSetting(name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s:%d: %v", path, n, err))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
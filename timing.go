@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timingWriter is where time and bench statements print their measurements.
+var timingWriter io.Writer = textOut
+
+// benchTime is how long bench runs an expression for when the number of runs isn't
+// given, and benchMaxRuns is the most times it's run then.
+const (
+	benchTime    = time.Second
+	benchMaxRuns = 1000000
+)
+
+// timedEntrypoint is set to the option that parses the Timed rule when the package is
+// initialized, for the same reason as explainEntrypoint.
+var timedEntrypoint Option
+
+func init() {
+	timedEntrypoint = Entrypoint("Timed")
+}
+
+// allocStats is the memory allocated by an evaluation.
+type allocStats struct {
+	mallocs, bytes uint64
+}
+
+func readAllocs() allocStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return allocStats{m.Mallocs, m.TotalAlloc}
+}
+
+func (a allocStats) since(before allocStats) allocStats {
+	return allocStats{a.mallocs - before.mallocs, a.bytes - before.bytes}
+}
+
+func (a allocStats) String() string {
+	return fmt.Sprintf("%d allocations (%s)", a.mallocs, formatBytes(a.bytes))
+}
+
+// evalTimed evaluates the expression src once.
+func evalTimed(src string) (interface{}, error) {
+	v, err := funcParse("time", []byte(src), timedEntrypoint)
+	if err != nil {
+		// The positions of errors are within src rather than the statement
+		return nil, errors.New(errorMessage(firstError(err)))
+	}
+	return v, nil
+}
+
+// timeExpr evaluates the expression src and prints its result followed by how long it
+// took and the memory it allocated.
+func timeExpr(src string) error {
+	before := readAllocs()
+	start := time.Now()
+	v, err := evalTimed(src)
+	elapsed := time.Since(start)
+	allocs := readAllocs().since(before)
+	if err != nil {
+		return err
+	}
+
+	printResult(v)
+	fmt.Fprintf(timingWriter, "time %v, %s\n", roundDuration(elapsed), allocs)
+	return nil
+}
+
+// benchStmt runs the bench statement whose operand is s: an expression, optionally
+// followed by a comma and the number of runs.
+func benchStmt(s string) error {
+	src, runs := s, 0
+	if i := lastTopLevelComma(s); i >= 0 {
+		n, err := strconv.Atoi(strings.TrimSpace(s[i+1:]))
+		if err != nil || n <= 0 {
+			return fmt.Errorf("the number of runs must be a positive integer, not %s", strings.TrimSpace(s[i+1:]))
+		}
+		src, runs = s[:i], n
+	}
+	if strings.TrimSpace(src) == "" {
+		return fmt.Errorf("expected an expression before the number of runs")
+	}
+	return benchExpr(src, runs)
+}
+
+// lastTopLevelComma returns the index of the last comma in s that isn't within brackets
+// or a string, or -1 if there is none.
+func lastTopLevelComma(s string) int {
+	last, depth, inString := -1, 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			last = i
+		}
+	}
+	return last
+}
+
+// benchExpr evaluates the expression src runs times and prints the mean and percentiles
+// of the time each run took. If runs is 0 it's run for about benchTime.
+func benchExpr(src string, runs int) error {
+	var times []time.Duration
+	before := readAllocs()
+	start := time.Now()
+	for runs == 0 && len(times) < benchMaxRuns && time.Since(start) < benchTime || len(times) < runs {
+		// Evaluating may not reach a step, for example for a number alone
+		if err := checkInterrupt(); err != nil {
+			return err
+		}
+		t := time.Now()
+		if _, err := evalTimed(src); err != nil {
+			return err
+		}
+		times = append(times, time.Since(t))
+	}
+	allocs := readAllocs().since(before)
+
+	var total time.Duration
+	for _, t := range times {
+		total += t
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	n := uint64(len(times))
+
+	fmt.Fprintf(timingWriter, "%d runs: mean %v, p50 %v, p90 %v, p99 %v, max %v\n", n,
+		roundDuration(total/time.Duration(n)), roundDuration(percentile(times, 50)),
+		roundDuration(percentile(times, 90)), roundDuration(percentile(times, 99)),
+		roundDuration(times[n-1]))
+	fmt.Fprintf(timingWriter, "%s per run\n", allocStats{allocs.mallocs / n, allocs.bytes / n})
+	return nil
+}
+
+// percentile returns the pth percentile of the sorted durations, the smallest that at
+// least p percent of them are less than or equal to.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p + 99) / 100
+	if i > 0 {
+		i--
+	}
+	return sorted[i]
+}
+
+// roundDuration rounds d to 3 significant digits, which is as precise as timing an
+// evaluation is.
+func roundDuration(d time.Duration) time.Duration {
+	r := time.Duration(1)
+	for d/r >= 1000 {
+		r *= 10
+	}
+	return d.Round(r)
+}
+
+// formatBytes returns n as a number of bytes, KB, MB or GB.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	v := float64(n) / unit
+	for _, suffix := range []string{"KB", "MB"} {
+		if v < unit {
+			return fmt.Sprintf("%.1f %s", v, suffix)
+		}
+		v /= unit
+	}
+	return fmt.Sprintf("%.1f GB", v)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Frame is a call of a function. When an error occurs, Pos is the position in the
+// function's body at which it occurred.
+type Frame struct {
+	Name   string
+	Params []string
+	Args   []interface{}
+	Body   string
+	Pos    position
+}
+
+// callStack holds the calls of user-defined functions that are currently being
+// evaluated, outermost first.
+var callStack []*Frame
+
+func pushFrame(f *DefinedFunc, args []interface{}) {
+	callStack = append(callStack, &Frame{Name: f.name, Params: f.paramNames, Args: args, Body: string(f.body)})
+}
+
+func popFrame() {
+	callStack = callStack[:len(callStack)-1]
+}
+
+// traced holds the names of the functions whose calls are traced.
+var traced = map[string]bool{}
+
+// traceWriter is where traced calls are written.
+var traceWriter io.Writer = os.Stderr
+
+// traceDepth is the number of traced calls being evaluated, which the calls inside them
+// are indented by.
+var traceDepth int
+
+// traceFuncs starts tracing the functions called names, or prints the functions being
+// traced if there are none.
+func traceFuncs(names []string) error {
+	if len(names) == 0 {
+		for _, k := range tracedNames() {
+			fmt.Fprintln(textOut, k)
+		}
+		return nil
+	}
+	for _, n := range names {
+		if _, ok := Funcs[n]; !ok {
+			return ErrNoSuchFunc{n}
+		}
+	}
+	for _, n := range names {
+		traced[n] = true
+	}
+	return nil
+}
+
+// untraceFuncs stops tracing the functions called names, or all functions if there are
+// none.
+func untraceFuncs(names []string) error {
+	if len(names) == 0 {
+		traced = map[string]bool{}
+		return nil
+	}
+	for _, n := range names {
+		if !traced[n] {
+			return fmt.Errorf("%s isn't traced", n)
+		}
+		delete(traced, n)
+	}
+	return nil
+}
+
+func tracedNames() []string {
+	names := make([]string, 0, len(traced))
+	for k := range traced {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// traceCall writes the call fr of a traced function. It returns a function that writes
+// the call's result or error, which is deferred until the call returns. The calls made
+// while evaluating it are indented below it.
+func traceCall(fr Frame) func(result *interface{}, err *error) {
+	indent := strings.Repeat("  ", traceDepth)
+	call := fr.describe()
+	fmt.Fprintf(traceWriter, "%s-> %s\n", indent, call)
+	traceDepth++
+	return func(result *interface{}, err *error) {
+		traceDepth--
+		if *err != nil {
+			fmt.Fprintf(traceWriter, "%s<- %s: Error: %s\n", indent, call, errorMessage(firstError(*err)))
+			return
+		}
+		fmt.Fprintf(traceWriter, "%s<- %s = %s\n", indent, call, argValue(*result))
+	}
+}
+
+// lambdaName returns the name of a lambda defined at pos, which identifies where it
+// was defined: either the top level input or the body of the function being called.
+func lambdaName(pos position) string {
+	if len(callStack) == 0 {
+		return fmt.Sprintf("lambda@%d:%d", pos.line, pos.col)
+	}
+	return fmt.Sprintf("lambda@%s:%d:%d", callStack[len(callStack)-1].Name, pos.line, pos.col)
+}
+
+// CallError is an error that occurred while calling a function. Trace lists the calls that
+// were being evaluated, innermost first.
+type CallError struct {
+	Err   error
+	Trace []Frame
+}
+
+func (e *CallError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CallError) Unwrap() error {
+	return e.Err
+}
+
+// withFrame adds the call fr to the trace of err, which was returned while evaluating
+// the call.
+func withFrame(err error, fr Frame) error {
+	e := firstError(err)
+	if e == errNoValue {
+		return err
+	}
+
+	if pos, _, ok := errorPosition(e); ok {
+		fr.Pos = pos
+	}
+
+	inner := stripPosition(e)
+	if ce, ok := inner.(*CallError); ok {
+		return &CallError{Err: ce.Err, Trace: append(ce.Trace, fr)}
+	}
+	return &CallError{Err: inner, Trace: []Frame{fr}}
+}
+
+// firstError returns the first error in err that isn't caused by an earlier one.
+func firstError(err error) error {
+	el, ok := err.(errList)
+	if !ok {
+		return err
+	}
+	for _, e := range el {
+		if _, inner, _ := errorPosition(e); inner != errNoValue {
+			return e
+		}
+	}
+	if len(el) > 0 {
+		return errNoValue
+	}
+	return err
+}
+
+// stripPosition removes the position information added by the parser from err.
+func stripPosition(err error) error {
+	for {
+		switch t := err.(type) {
+		case errList:
+			if len(t) == 0 {
+				return err
+			}
+			err = firstError(t)
+		case *parserError:
+			err = t.Inner
+		case *posError:
+			err = t.err
+		default:
+			return err
+		}
+	}
+}
+
+// callTrace returns the trace of calls attached to err, if any.
+func callTrace(err error) []Frame {
+	if ce, ok := stripPosition(err).(*CallError); ok {
+		return ce.Trace
+	}
+	return nil
+}
+
+// printTrace prints the calls in trace, innermost first, each with the line of the
+// function's body at which the error occurred. Long traces, such as from runaway
+// recursion, are shortened by omitting the calls in the middle.
+func printTrace(w io.Writer, trace []Frame) {
+	const head, tail = 10, 3
+	for i, fr := range trace {
+		if len(trace) > head+tail+1 && i >= head && i < len(trace)-tail {
+			if i == head {
+				fmt.Fprintf(w, "  ... %d more calls ...\n", len(trace)-head-tail)
+			}
+			continue
+		}
+		if fr.Pos.line < 1 {
+			fmt.Fprintf(w, "  in %s\n", fr.describe())
+			continue
+		}
+		fmt.Fprintf(w, "  in %s at %d:%d\n", fr.describe(), fr.Pos.line, fr.Pos.col)
+		lines := strings.Split(fr.Body, "\n")
+		if fr.Pos.line <= len(lines) {
+			line := lines[fr.Pos.line-1]
+			fmt.Fprintf(w, "    %s\n    %s^\n", line, caretPadding(line, fr.Pos.col))
+		}
+	}
+}
+
+// describe returns the call as it would be written, with the names of the parameters
+// if they are known. For example f(x=1, y=2).
+func (fr Frame) describe() string {
+	var buf bytes.Buffer
+	buf.WriteString(fr.Name)
+	buf.WriteRune('(')
+	for i, a := range fr.Args {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		if i < len(fr.Params) {
+			fmt.Fprintf(&buf, "%s=", fr.Params[i])
+		}
+		buf.WriteString(argValue(a))
+	}
+	buf.WriteRune(')')
+	return buf.String()
+}
+
+// argValue returns the value v as it is shown in a call. Strings are quoted, as they are
+// written, so that they can be told apart from names.
+func argValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		lit, _ := literal(s)
+		return lit
+	}
+	return displayValue(v)
+}
+
+// formatValue returns the value v as it is displayed in the results.
+func formatValue(v interface{}) string {
+	switch t := v.(type) {
+	case *big.Int:
+		if t == nil {
+			return "<nil>"
+		}
+		return outputBase.format(t)
+	case *big.Float:
+		if t == nil {
+			return "<nil>"
+		}
+		return fmt.Sprintf("%f", t)
+	case BigIntList:
+		var buf bytes.Buffer
+		buf.WriteRune('[')
+		for i, n := range t {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(outputBase.format(n))
+		}
+		buf.WriteRune(']')
+		return buf.String()
+	case *DefinedFunc:
+		return t.name
+	case *BuiltinFunc:
+		return t.name
+	}
+	return fmt.Sprint(v)
+}
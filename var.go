@@ -10,14 +10,20 @@ var GlobalVars = map[string]interface{}{}
 // Parameters of DefinedFunctions are local vars
 var LocalVars = map[string]interface{}{}
 
-type ErrUnboundVar string
+type ErrUnboundVar struct {
+	Name string
+}
 
 func NewErrUnboundVar(name string) ErrUnboundVar {
-	return ErrUnboundVar(fmt.Sprintf("Unbound variable %s", name))
+	return ErrUnboundVar{name}
 }
 
 func (e ErrUnboundVar) Error() string {
-	return string(e)
+	return fmt.Sprintf("Unbound variable %s", e.Name)
+}
+
+func (e ErrUnboundVar) Suggestions() []string {
+	return suggestNames(e.Name, knownNames())
 }
 
 func Resolve(varName string) (interface{}, error) {
@@ -49,7 +55,7 @@ func ResolveStrict(varName string) (interface{}, error) {
 
 func SetGlobal(name string, val interface{}) {
 	GlobalVars[name] = val
-	updateAutocomplete()
+	completions.add(name)
 }
 
 func ClearLocals() {
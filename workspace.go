@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The workspace is the variables and user-defined functions of the session. These
+// functions implement the statements that inspect and change it.
+
+// userVarNames returns the names of the variables that have a value, sorted. The
+// numbered results and the script arguments are left out.
+func userVarNames() []string {
+	names := make([]string, 0, len(GlobalVars))
+	for k, v := range GlobalVars {
+		if v != nil && isIdentifier(k) && !isResultName(k) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// userFuncs returns the user-defined functions in the order they were defined.
+func userFuncs() []*DefinedFunc {
+	var funcs []*DefinedFunc
+	for _, f := range Funcs {
+		if df, ok := f.(*DefinedFunc); ok {
+			funcs = append(funcs, df)
+		}
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].seq < funcs[j].seq })
+	return funcs
+}
+
+// funcSource returns the definition of the function f as it would be written: a def
+// statement for a named function, or a lambda.
+func funcSource(f Func) string {
+	switch t := f.(type) {
+	case *DefinedFunc:
+		params := strings.Join(t.paramNames, ", ")
+		body := strings.TrimSpace(string(t.body))
+		if g, ok := Funcs[t.name]; ok && g == Func(t) {
+			return fmt.Sprintf("def %s(%s)%s %s", t.name, params, helpLiteral(t.help), body)
+		}
+		return fmt.Sprintf("def(%s)%s {%s}", params, helpLiteral(t.help), body)
+	case *BuiltinFunc:
+		return fmt.Sprintf("%s: builtin function: %s", t.name, t.help)
+	}
+	return fmt.Sprint(f)
+}
+
+// displayValue returns the value v as it is displayed by vars and show. Lambdas are
+// shown with their definition, and other functions by name.
+func displayValue(v interface{}) string {
+	if f, ok := v.(*DefinedFunc); ok {
+		if g, ok := Funcs[f.name]; !ok || g != Func(f) {
+			return funcSource(f)
+		}
+	}
+	return formatValue(v)
+}
+
+func printVars() {
+	for _, k := range userVarNames() {
+		fmt.Fprintf(textOut, "%s = %s\n", k, displayValue(GlobalVars[k]))
+	}
+}
+
+func printFuncs() {
+	for _, f := range userFuncs() {
+		fmt.Fprintln(textOut, funcSource(f))
+	}
+}
+
+// show prints the value of the variable or the definition of the function called name.
+func show(name string) error {
+	if v, ok := GlobalVars[name]; ok {
+		fmt.Fprintf(textOut, "%s = %s\n", name, displayValue(v))
+		return nil
+	}
+	if f, ok := Funcs[name]; ok {
+		fmt.Fprintln(textOut, funcSource(f))
+		return nil
+	}
+	return NewErrUnboundVar(name)
+}
+
+type ErrBuiltin struct {
+	Name string
+}
+
+func (e ErrBuiltin) Error() string {
+	return fmt.Sprintf("%s is a builtin function and can't be undefined", e.Name)
+}
+
+// undef deletes the variable or user-defined function called name. If there are both,
+// the variable is deleted, since it is the one that the name refers to.
+func undef(name string) error {
+	defer completions.remove(name)
+
+	if _, ok := GlobalVars[name]; ok {
+		delete(GlobalVars, name)
+		return nil
+	}
+	switch Funcs[name].(type) {
+	case *DefinedFunc:
+		delete(Funcs, name)
+		delete(traced, name)
+		return nil
+	case *BuiltinFunc:
+		return ErrBuiltin{name}
+	}
+	return NewErrUnboundVar(name)
+}
+
+// resetWorkspace deletes all variables and user-defined functions. Settings and the
+// history of inputs are kept.
+func resetWorkspace() {
+	for k := range GlobalVars {
+		delete(GlobalVars, k)
+		completions.remove(k)
+	}
+	for k, f := range Funcs {
+		if _, ok := f.(*DefinedFunc); ok {
+			delete(Funcs, k)
+			delete(traced, k)
+			completions.remove(k)
+		}
+	}
+}